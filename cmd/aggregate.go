@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// writeStages are the aggregation stages that write to a collection, which
+// runAggregation refuses so it stays read-only.
+var writeStages = map[string]bool{
+	"$out":   true,
+	"$merge": true,
+}
+
+// collection returns the API collection with the given name, or nil when
+// there is none.
+func (s *Store) collection(name string) *mongo.Collection {
+	for _, coll := range []*mongo.Collection{s.customers, s.products, s.orders} {
+		if coll.Name() == name {
+			return coll
+		}
+	}
+	return nil
+}
+
+// runAggregation runs the aggregation pipeline in the request body against
+// the collection in the path and responds with the results. The body is a
+// JSON array of stages in MongoDB extended JSON, so values like
+// {"$oid": "..."} and {"$date": "..."} can be used. It must be routed behind
+// requireAdmin.
+func (s *Store) runAggregation(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	coll := s.collection(c.Params("collection"))
+	if coll == nil {
+		return errorResponse(c, 404, "Collection not found", nil)
+	}
+
+	// Extended JSON can only be decoded from a document, so wrap the array
+	raw := append(append([]byte(`{"pipeline":`), c.Body()...), '}')
+	var body struct {
+		Pipeline []bson.D `bson:"pipeline"`
+	}
+	if err := bson.UnmarshalExtJSON(raw, false, &body); err != nil {
+		return errorResponse(c, 400, "Invalid pipeline", err)
+	}
+	if len(body.Pipeline) == 0 {
+		return errorResponse(c, 400, "Pipeline is empty", nil)
+	}
+
+	for i, stage := range body.Pipeline {
+		if len(stage) != 1 {
+			return errorResponse(c, 400, "Invalid pipeline", fmt.Errorf("stage %d must have exactly one operator", i))
+		}
+		if writeStages[stage[0].Key] {
+			return errorResponse(c, 400, "Pipeline may not write", fmt.Errorf("stage %d uses %s", i, stage[0].Key))
+		}
+	}
+
+	cursor, err := coll.Aggregate(ctx, body.Pipeline)
+	if err != nil {
+		return errorResponse(c, 500, "Error running aggregation", err)
+	}
+	defer cursor.Close(ctx)
+
+	results, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error running aggregation", err)
+	}
+
+	return respond(c, normalizeDocs(results), fiber.Map{"count": len(results)})
+}
+
+// explainVerbosities are the explain verbosity modes clients may ask for.
+var explainVerbosities = map[string]bool{
+	"queryPlanner":      true,
+	"executionStats":    true,
+	"allPlansExecution": true,
+}
+
+// explainQuery responds with the server's explain output for a find on the
+// collection in the path with the filter in the request body, given in
+// MongoDB extended JSON. ?verbosity= picks the explain mode, queryPlanner by
+// default. It must be routed behind requireAdmin since executionStats runs
+// the query.
+func (s *Store) explainQuery(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	coll := s.collection(c.Params("collection"))
+	if coll == nil {
+		return errorResponse(c, 404, "Collection not found", nil)
+	}
+
+	verbosity := c.Query("verbosity", "queryPlanner")
+	if !explainVerbosities[verbosity] {
+		return errorResponse(c, 400, "Invalid verbosity parameter", fmt.Errorf("unknown verbosity %q", verbosity))
+	}
+
+	filter := bson.D{}
+	if len(c.Body()) > 0 {
+		if err := bson.UnmarshalExtJSON(c.Body(), false, &filter); err != nil {
+			return errorResponse(c, 400, "Invalid filter", err)
+		}
+	}
+
+	cmd := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: coll.Name()},
+			{Key: "filter", Value: filter},
+		}},
+		{Key: "verbosity", Value: verbosity},
+	}
+	var plan bson.M
+	if err := s.database.RunCommand(ctx, cmd).Decode(&plan); err != nil {
+		return errorResponse(c, 500, "Error explaining query", err)
+	}
+
+	return respond(c, normalizeDoc(plan), nil)
+}
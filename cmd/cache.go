@@ -0,0 +1,193 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxCacheEntries bounds the number of responses a responseCache holds, since
+// every distinct query string gets an entry of its own.
+const maxCacheEntries = 1000
+
+// cacheEntry is a cached response and the collections it was computed from.
+type cacheEntry struct {
+	body        []byte
+	contentType string
+	collections []string
+	expires     time.Time
+}
+
+// responseCache keeps the successful responses of expensive read endpoints
+// in memory for ttl, keyed by tenant, path and query. Entries are dropped
+// early when a write touches a collection they were computed from, and at
+// most maxCacheEntries are kept. A nil responseCache caches nothing, so its
+// methods are safe to use when caching is turned off.
+type responseCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	// generations counts the invalidations of each collection, so a response
+	// computed while a write landed isn't cached
+	generations map[string]uint64
+}
+
+// newResponseCache returns a cache holding responses for ttl, or nil when
+// ttl is 0.
+func newResponseCache(ttl time.Duration) *responseCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &responseCache{ttl: ttl, entries: map[string]cacheEntry{}, generations: map[string]uint64{}}
+}
+
+// handler serves the route's response from the cache while it is fresh, and
+// otherwise caches it if it succeeds. collections are the ones the response
+// is computed from. The X-Cache header says whether the cache was hit.
+func (rc *responseCache) handler(collections ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rc == nil {
+			return c.Next()
+		}
+
+		key := c.Get("X-Tenant-DB") + " " + c.OriginalURL()
+		now := time.Now()
+
+		rc.mu.Lock()
+		entry, ok := rc.entries[key]
+		rc.mu.Unlock()
+		if ok && now.Before(entry.expires) {
+			c.Set("X-Cache", "HIT")
+			c.Set(fiber.HeaderContentType, entry.contentType)
+			return c.Send(entry.body)
+		}
+
+		c.Set("X-Cache", "MISS")
+		generation := rc.generation(collections)
+		if err := c.Next(); err != nil {
+			return err
+		}
+		if c.Response().StatusCode() != fiber.StatusOK {
+			return nil
+		}
+
+		// The response body is reused once the request is done, so copy it
+		entry = cacheEntry{
+			body:        append([]byte(nil), c.Response().Body()...),
+			contentType: string(c.Response().Header.ContentType()),
+			collections: collections,
+			expires:     now.Add(rc.ttl),
+		}
+		rc.store(key, entry, now, generation)
+		return nil
+	}
+}
+
+// generation returns the combined invalidation count of collections. Counts
+// only grow, so it changes whenever any of them is invalidated.
+func (rc *responseCache) generation(collections []string) uint64 {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return rc.generationLocked(collections)
+}
+
+func (rc *responseCache) generationLocked(collections []string) uint64 {
+	var sum uint64
+	for _, coll := range collections {
+		sum += rc.generations[coll]
+	}
+	return sum
+}
+
+// store adds entry to the cache under key, unless its collections have been
+// invalidated since generation was read, which would make it stale. When the
+// cache is full, expired entries are dropped first, and if none have expired
+// the one expiring soonest makes room.
+func (rc *responseCache) store(key string, entry cacheEntry, now time.Time, generation uint64) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.generationLocked(entry.collections) != generation {
+		return
+	}
+
+	if _, ok := rc.entries[key]; !ok && len(rc.entries) >= maxCacheEntries {
+		oldest := ""
+		for k, e := range rc.entries {
+			if !now.Before(e.expires) {
+				delete(rc.entries, k)
+			} else if oldest == "" || e.expires.Before(rc.entries[oldest].expires) {
+				oldest = k
+			}
+		}
+		if len(rc.entries) >= maxCacheEntries {
+			delete(rc.entries, oldest)
+		}
+	}
+	rc.entries[key] = entry
+}
+
+// invalidate drops every entry computed from any of collections, across all
+// tenants, along with any expired entries.
+func (rc *responseCache) invalidate(collections ...string) {
+	if rc == nil {
+		return
+	}
+
+	now := time.Now()
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for _, coll := range collections {
+		rc.generations[coll]++
+	}
+	for key, entry := range rc.entries {
+		if !now.Before(entry.expires) || overlaps(entry.collections, collections) {
+			delete(rc.entries, key)
+		}
+	}
+}
+
+func overlaps(a, b []string) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// writtenCollections maps the collection segment of a write route's path to
+// the collections the write may change. Orders also change product stock.
+var writtenCollections = map[string][]string{
+	"customers": {"customers"},
+	"products":  {"products"},
+	"orders":    {"orders", "products"},
+}
+
+// invalidateOnWrite invalidates the cache for the collections a successful
+// write request may have changed, once it has been handled.
+func (rc *responseCache) invalidateOnWrite(c *fiber.Ctx) error {
+	err := c.Next()
+	if rc == nil {
+		return err
+	}
+
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+		return err
+	}
+	if status := responseStatus(c, err); status >= 400 {
+		return err
+	}
+
+	segments := strings.Split(strings.TrimPrefix(c.Path(), "/api/"), "/")
+	if collections, ok := writtenCollections[segments[0]]; ok {
+		rc.invalidate(collections...)
+	}
+	return err
+}
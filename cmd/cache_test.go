@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestResponseCacheStoreIsBounded(t *testing.T) {
+	rc := newResponseCache(time.Minute)
+	now := time.Now()
+
+	for i := 0; i < maxCacheEntries+10; i++ {
+		rc.store(fmt.Sprint(i), cacheEntry{expires: now.Add(time.Duration(i) * time.Second)}, now, 0)
+	}
+	if len(rc.entries) != maxCacheEntries {
+		t.Fatalf("cache holds %d entries, want %d", len(rc.entries), maxCacheEntries)
+	}
+	if _, ok := rc.entries["0"]; ok {
+		t.Error("the entry expiring soonest was kept")
+	}
+	if _, ok := rc.entries[fmt.Sprint(maxCacheEntries+9)]; !ok {
+		t.Error("the newest entry was evicted")
+	}
+}
+
+func TestResponseCacheStoreDropsExpired(t *testing.T) {
+	rc := newResponseCache(time.Minute)
+	now := time.Now()
+
+	for i := 0; i < maxCacheEntries; i++ {
+		expires := now.Add(time.Minute)
+		if i%2 == 0 {
+			expires = now.Add(-time.Second)
+		}
+		rc.store(fmt.Sprint(i), cacheEntry{expires: expires}, now, 0)
+	}
+	rc.store("new", cacheEntry{expires: now.Add(time.Minute)}, now, 0)
+
+	if want := maxCacheEntries/2 + 1; len(rc.entries) != want {
+		t.Fatalf("cache holds %d entries, want %d", len(rc.entries), want)
+	}
+	for key, entry := range rc.entries {
+		if !now.Before(entry.expires) {
+			t.Errorf("expired entry %s was kept", key)
+		}
+	}
+}
+
+// TestResponseCacheSkipsStaleResponses checks that a response computed while
+// a write invalidated its collection isn't cached.
+func TestResponseCacheSkipsStaleResponses(t *testing.T) {
+	rc := newResponseCache(time.Minute)
+	app := fiber.New()
+	writeDuringRead := true
+	app.Get("/report", rc.handler("orders"), func(c *fiber.Ctx) error {
+		if writeDuringRead {
+			writeDuringRead = false
+			rc.invalidate("orders")
+		}
+		return c.SendString("report")
+	})
+
+	var hits []string
+	for i := 0; i < 3; i++ {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/report", nil), -1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hits = append(hits, resp.Header.Get("X-Cache"))
+	}
+	if got, want := strings.Join(hits, ","), "MISS,MISS,HIT"; got != want {
+		t.Errorf("X-Cache = %s, want %s", got, want)
+	}
+}
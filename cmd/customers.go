@@ -0,0 +1,463 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Customer struct {
+	ID    primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	Name  string             `json:"name" bson:"name" validate:"required,max=100"`
+	Email string             `json:"email" bson:"email" validate:"required,email,max=254"`
+	Phone string             `json:"phone,omitempty" bson:"phone,omitempty" validate:"omitempty,max=32"`
+
+	// Version is incremented on every update, for optimistic concurrency
+	Version int `json:"version" bson:"version"`
+
+	CreatedAt time.Time  `json:"created_at" bson:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
+}
+
+// customerFilter builds the filter for the customer list query parameters:
+// the customerPolicy equality filters, a created_at range from the from
+// and to parameters, and the exists and type schema filters.
+func customerFilter(c *fiber.Ctx) (bson.M, error) {
+	filter, err := buildFilter(c, customerPolicy.filter)
+	if err != nil {
+		return nil, err
+	}
+	dateRange, err := parseDateRange(c, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	for field, cond := range dateRange {
+		filter[field] = cond
+	}
+
+	schema, err := parseSchemaFilter(c, customerPolicy.project)
+	if err != nil {
+		return nil, err
+	}
+	for field, cond := range schema {
+		filter[field] = cond
+	}
+	return filter, nil
+}
+
+// customerSearchFields lists the customer fields searchCustomers matches on.
+var customerSearchFields = []string{"name", "email"}
+
+// searchCustomers responds with the customers with a searchable field that
+// matches the q parameter, ignoring case, in the way ?mode= selects; see
+// searchPattern.
+func (s *Store) searchCustomers(c *fiber.Ctx) error {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		return errorResponse(c, 400, "Search query is required", nil)
+	}
+
+	pattern, err := searchPattern(q, c.Query("mode", searchContains))
+	if err != nil {
+		return errorResponse(c, 400, "Invalid search mode", err)
+	}
+	or := bson.A{}
+	for _, field := range customerSearchFields {
+		or = append(or, bson.M{field: bson.M{"$regex": pattern, "$options": "i"}})
+	}
+
+	return s.listCustomers(c, bson.M{"$or": or})
+}
+
+// listCustomers responds with a page of the customers matching filter, by
+// offset or, given ?after=, by keyset.
+func (s *Store) listCustomers(c *fiber.Ctx, filter bson.M) error {
+	if after := c.Query("after"); after != "" {
+		return s.listCustomersAfter(c, filter, after)
+	}
+	return s.listDocuments(c, s.customers, filter, customerPolicy)
+}
+
+// listCustomersAfter responds with the customers whose _id sorts after the
+// after cursor. Keyset pagination stays fast however deep the page, unlike
+// skipping. Start from the zero ObjectID, 000000000000000000000000, and pass
+// each response's next_cursor to get the following page; it is null on the
+// last one.
+func (s *Store) listCustomersAfter(c *fiber.Ctx, filter bson.M, after string) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	afterID, err := parseObjectID(after)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid after cursor", err)
+	}
+	excludeDeleted(c, filter)
+	filter["_id"] = bson.M{"$gt": afterID}
+
+	_, limit := parsePagination(c, s.pageSize(s.customers.Name()))
+	projection, err := parseProjection(c.Query("fields"), customerPolicy.project)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid fields parameter", err)
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}).SetLimit(limit)
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := s.customers.Find(ctx, filter, opts)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding customers", err)
+	}
+	defer cursor.Close(ctx)
+
+	customers, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding customers", err)
+	}
+
+	// A short page means there is nothing after it
+	var nextCursor interface{}
+	if int64(len(customers)) == limit {
+		if lastID, ok := customers[len(customers)-1]["_id"].(primitive.ObjectID); ok {
+			nextCursor = lastID.Hex()
+		}
+	}
+
+	return respond(c, normalizeDocs(customers), fiber.Map{
+		"count":       len(customers),
+		"limit":       limit,
+		"next_cursor": nextCursor,
+	})
+}
+
+func (s *Store) countCustomers(c *fiber.Ctx) error {
+	filter, err := customerFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	return countDocuments(c, s.customers, filter)
+}
+
+// customerIDFilter matches a customer by id. Legacy customers imported from
+// the old system have string IDs, so the raw string is matched as well as
+// the ObjectID it parses to. The returned error reports whether id was a
+// valid ObjectID; the filter is usable either way.
+func customerIDFilter(id string) (bson.M, error) {
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return bson.M{"_id": id}, err
+	}
+	return bson.M{"_id": bson.M{"$in": bson.A{objectID, id}}}, nil
+}
+
+func (s *Store) getCustomerByID(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+	var customer bson.M
+
+	filter, idErr := customerIDFilter(id)
+	excludeDeleted(c, filter)
+
+	if err := s.customers.FindOne(ctx, filter).Decode(&customer); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if idErr != nil {
+				return errorResponse(c, 400, "Invalid ID format", idErr)
+			}
+			return errorResponse(c, 404, "Customer not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding customer", err)
+	}
+
+	notModified, err := setETag(c, normalizeDoc(customer))
+	if err != nil {
+		return errorResponse(c, 500, "Error encoding customer", err)
+	}
+	if notModified {
+		return c.SendStatus(304)
+	}
+
+	return respond(c, customer, nil)
+}
+
+func (s *Store) createCustomer(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	if len(c.Body()) == 0 {
+		return errorResponse(c, 400, "Request body is empty", nil)
+	}
+
+	var customer Customer
+	if err := c.BodyParser(&customer); err != nil {
+		return bodyParseError(c, err)
+	}
+	if customer == (Customer{}) {
+		return errorResponse(c, 400, "Request body is empty", nil)
+	}
+	if errs := validateStruct(customer); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	// Let MongoDB generate the ID, and never trust a client-sent timestamp
+	customer.ID = primitive.NilObjectID
+	customer.CreatedAt = time.Now().UTC()
+	customer.UpdatedAt = nil
+	customer.Version = 1
+
+	result, err := s.customers.InsertOne(ctx, customer)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyResponse(c, "Customer", err)
+		}
+		return errorResponse(c, 500, "Error creating customer", err)
+	}
+
+	// ObjectID marshals to its hex string in JSON
+	customer.ID = result.InsertedID.(primitive.ObjectID)
+
+	return c.Status(201).JSON(customer)
+}
+
+// customerVersion matches customers at version. Customers created before
+// versioning have no version field and count as version 0.
+func customerVersion(version int) interface{} {
+	if version == 0 {
+		return bson.M{"$in": bson.A{0, nil}}
+	}
+	return version
+}
+
+// parseVersion reads the version a client sent with an update, the one of the
+// customer it last read.
+func parseVersion(raw interface{}) (int, error) {
+	if raw == nil {
+		return 0, errors.New("send the version of the customer being updated")
+	}
+	version, ok := raw.(float64)
+	if !ok || version < 0 || version != math.Trunc(version) {
+		return 0, errors.New("version must be a non-negative integer")
+	}
+	return int(version), nil
+}
+
+// versionConflict writes the 409 for an update sent with a version other than
+// the customer's current one, meaning someone else updated it first.
+func versionConflict(c *fiber.Ctx, expected, current int) error {
+	return errorResponse(c, 409, "Customer was updated by another request",
+		fmt.Errorf("expected version %d, but the current version is %d", expected, current))
+}
+
+// replaceCustomer replaces the whole customer document with the request body,
+// keeping only its ID and creation time. The body must carry the version of
+// the customer being replaced. Soft-deleted customers can't be replaced.
+func (s *Store) replaceCustomer(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	// Soft-deleted customers are treated as missing
+	filter, idErr := customerIDFilter(c.Params("id"))
+	filter["deleted_at"] = bson.M{"$exists": false}
+
+	if len(c.Body()) == 0 {
+		return errorResponse(c, 400, "Request body is empty", nil)
+	}
+
+	var body struct {
+		Customer
+		// Shadows Customer.Version so a missing version can be told apart
+		Version *int `json:"version"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bodyParseError(c, err)
+	}
+	if body.Version == nil {
+		return errorResponse(c, 400, "A version is required", errors.New("send the version of the customer being replaced"))
+	}
+	customer, expected := body.Customer, *body.Version
+	if errs := validateStruct(customer); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	// Decoded loosely since legacy customers have string IDs
+	var existing struct {
+		ID        interface{} `bson:"_id"`
+		CreatedAt time.Time   `bson:"created_at"`
+		Version   int         `bson:"version"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"created_at": 1, "version": 1})
+	if err := s.customers.FindOne(ctx, filter, opts).Decode(&existing); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if idErr != nil {
+				return errorResponse(c, 400, "Invalid ID format", idErr)
+			}
+			return errorResponse(c, 404, "Customer not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding customer", err)
+	}
+	if existing.Version != expected {
+		return versionConflict(c, expected, existing.Version)
+	}
+
+	// Leaving the ID out of the replacement keeps the existing one
+	now := time.Now().UTC()
+	customer.ID = primitive.NilObjectID
+	customer.CreatedAt = existing.CreatedAt
+	customer.UpdatedAt = &now
+	customer.Version = expected + 1
+
+	// Matching on the version too keeps a concurrent update from being lost
+	// between the read above and the replace
+	filter = bson.M{
+		"_id":        existing.ID,
+		"version":    customerVersion(expected),
+		"deleted_at": bson.M{"$exists": false},
+	}
+	result, err := s.customers.ReplaceOne(ctx, filter, customer)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyResponse(c, "Customer", err)
+		}
+		return errorResponse(c, 500, "Error replacing customer", err)
+	}
+	if result.MatchedCount == 0 {
+		return errorResponse(c, 409, "Customer was updated by another request", nil)
+	}
+
+	var replaced bson.M
+	if err := s.customers.FindOne(ctx, bson.M{"_id": existing.ID}).Decode(&replaced); err != nil {
+		return errorResponse(c, 500, "Error finding customer", err)
+	}
+
+	return c.JSON(normalizeDoc(replaced))
+}
+
+// patchCustomer sets only the fields present in the request body, leaving the
+// rest of the customer untouched. The body must carry the version of the
+// customer being updated. Soft-deleted customers can't be patched.
+func (s *Store) patchCustomer(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	// Soft-deleted customers are treated as missing, here and in patchMissed
+	filter, idErr := customerIDFilter(c.Params("id"))
+	filter["deleted_at"] = bson.M{"$exists": false}
+
+	var fields map[string]interface{}
+	if err := c.BodyParser(&fields); err != nil {
+		return bodyParseError(c, err)
+	}
+	if err := checkDocument(fields); err != nil {
+		return errorResponse(c, 400, "Invalid request body", err)
+	}
+
+	expected, err := parseVersion(fields["version"])
+	if err != nil {
+		return errorResponse(c, 400, "A version is required", err)
+	}
+
+	// The ID and creation time are immutable, the version is only ever
+	// incremented, and deleted_at is only set by deleteCustomer, so never try
+	// to set them
+	delete(fields, "_id")
+	delete(fields, "created_at")
+	delete(fields, "updated_at")
+	delete(fields, "deleted_at")
+	delete(fields, "version")
+	if len(fields) == 0 {
+		return errorResponse(c, 400, "No fields to update", nil)
+	}
+	if errs := validateFields("", fields, customerRules, true); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+	fields["updated_at"] = time.Now().UTC()
+
+	versioned := bson.M{"version": customerVersion(expected)}
+	for key, value := range filter {
+		versioned[key] = value
+	}
+	update := bson.M{"$set": fields, "$inc": bson.M{"version": 1}}
+
+	var customer bson.M
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := s.customers.FindOneAndUpdate(ctx, versioned, update, opts).Decode(&customer); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return s.patchMissed(c, filter, idErr, expected)
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyResponse(c, "Customer", err)
+		}
+		return errorResponse(c, 500, "Error updating customer", err)
+	}
+
+	return c.JSON(normalizeDoc(customer))
+}
+
+// patchMissed writes the response for a patch whose update matched nothing,
+// either because the customer matching filter doesn't exist or because it is
+// no longer at the expected version.
+func (s *Store) patchMissed(c *fiber.Ctx, filter bson.M, idErr error, expected int) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	var current struct {
+		Version int `bson:"version"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"version": 1})
+	if err := s.customers.FindOne(ctx, filter, opts).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if idErr != nil {
+				return errorResponse(c, 400, "Invalid ID format", idErr)
+			}
+			return errorResponse(c, 404, "Customer not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding customer", err)
+	}
+	return versionConflict(c, expected, current.Version)
+}
+
+// deleteCustomer removes a customer, or only marks it with deleted_at when
+// soft deletes are enabled.
+func (s *Store) deleteCustomer(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	filter, idErr := customerIDFilter(c.Params("id"))
+
+	var matched int64
+	if s.softDelete {
+		// Already deleted customers are treated as missing
+		filter["deleted_at"] = bson.M{"$exists": false}
+		update := bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}}
+		result, err := s.customers.UpdateOne(ctx, filter, update)
+		if err != nil {
+			return errorResponse(c, 500, "Error deleting customer", err)
+		}
+		matched = result.MatchedCount
+	} else {
+		result, err := s.customers.DeleteOne(ctx, filter)
+		if err != nil {
+			return errorResponse(c, 500, "Error deleting customer", err)
+		}
+		matched = result.DeletedCount
+	}
+
+	if matched == 0 {
+		if idErr != nil {
+			return errorResponse(c, 400, "Invalid ID format", idErr)
+		}
+		return errorResponse(c, 404, "Customer not found", nil)
+	}
+
+	return c.SendStatus(204)
+}
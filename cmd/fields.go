@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultFieldSample = 100
+	maxFieldSample     = 1000
+)
+
+// bsonTypeNames maps BSON types to the aliases MongoDB uses for them in
+// $type queries.
+var bsonTypeNames = map[bsontype.Type]string{
+	bsontype.Double:           "double",
+	bsontype.String:           "string",
+	bsontype.EmbeddedDocument: "object",
+	bsontype.Array:            "array",
+	bsontype.Binary:           "binData",
+	bsontype.Undefined:        "undefined",
+	bsontype.ObjectID:         "objectId",
+	bsontype.Boolean:          "bool",
+	bsontype.DateTime:         "date",
+	bsontype.Null:             "null",
+	bsontype.Regex:            "regex",
+	bsontype.DBPointer:        "dbPointer",
+	bsontype.JavaScript:       "javascript",
+	bsontype.Symbol:           "symbol",
+	bsontype.CodeWithScope:    "javascriptWithScope",
+	bsontype.Int32:            "int",
+	bsontype.Timestamp:        "timestamp",
+	bsontype.Int64:            "long",
+	bsontype.Decimal128:       "decimal",
+	bsontype.MinKey:           "minKey",
+	bsontype.MaxKey:           "maxKey",
+}
+
+// listFields samples documents from every collection and reports each field
+// with the BSON type observed for it, e.g. {"price": "double"}. A field seen
+// with several types across the sample lists all of them instead.
+func (s *Store) listFields(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	sample := c.QueryInt("sample", defaultFieldSample)
+	if sample < 1 {
+		sample = defaultFieldSample
+	}
+	if sample > maxFieldSample {
+		sample = maxFieldSample
+	}
+
+	// Get a list of all collection names in the database
+	collections, err := s.database.ListCollectionNames(ctx, bson.D{})
+	if err != nil {
+		return errorResponse(c, 500, "Error listing fields", err)
+	}
+
+	fields := make(map[string]map[string]interface{})
+
+	for _, collection := range collections {
+		types, err := sampleFieldTypes(ctx, s.database.Collection(collection), sample)
+		if err != nil {
+			return errorResponse(c, 500, "Error listing fields", err)
+		}
+		fields[collection] = types
+	}
+
+	return c.JSON(fields)
+}
+
+// sampleFieldTypes reports the types observed for each field across up to
+// sample documents of coll. It closes its cursor before returning so
+// iterating many collections doesn't hold cursors open.
+func sampleFieldTypes(ctx context.Context, coll *mongo.Collection, sample int) (map[string]interface{}, error) {
+	cursor, err := coll.Find(ctx, bson.D{}, options.Find().SetLimit(int64(sample)))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	// Inspect the raw documents so types are reported as stored
+	observed := make(map[string]map[string]bool)
+	for cursor.Next(ctx) {
+		elems, err := cursor.Current.Elements()
+		if err != nil {
+			return nil, err
+		}
+		for _, elem := range elems {
+			if observed[elem.Key()] == nil {
+				observed[elem.Key()] = make(map[string]bool)
+			}
+			observed[elem.Key()][bsonTypeNames[elem.Value().Type]] = true
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	types := make(map[string]interface{})
+	for field, names := range observed {
+		types[field] = typeList(names)
+	}
+	return types, nil
+}
+
+// typeList returns the single type name in types, or a sorted list of them
+// when there are several.
+func typeList(types map[string]bool) interface{} {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	if len(names) == 1 {
+		return names[0]
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+// healthMonitor pings MongoDB in the background and keeps the result, so
+// /healthz reports the connection's state without pinging on every probe and
+// a single slow request can't flip it.
+type healthMonitor struct {
+	healthy atomic.Bool
+	// lastErr is the error of the last failed ping, while unhealthy
+	lastErr atomic.Value
+}
+
+// newHealthMonitor returns a monitor that starts out healthy, since the
+// client has just connected.
+func newHealthMonitor() *healthMonitor {
+	m := &healthMonitor{}
+	m.healthy.Store(true)
+	m.lastErr.Store("")
+	return m
+}
+
+// run pings client every interval until ctx is done, logging when MongoDB
+// becomes unreachable and when it recovers.
+func (m *healthMonitor) run(ctx context.Context, logger *zap.Logger, client *mongo.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+		err := pingMongo(pingCtx, client)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			m.lastErr.Store(err.Error())
+			if m.healthy.Swap(false) {
+				logger.Error("MongoDB became unreachable, reporting not ready.", zap.Error(err))
+			} else {
+				logger.Warn("MongoDB is still unreachable.", zap.Error(err))
+			}
+			continue
+		}
+		if !m.healthy.Swap(true) {
+			logger.Info("MongoDB is reachable again, reporting ready.")
+		}
+		m.lastErr.Store("")
+	}
+}
+
+// healthCheck reports whether MongoDB is reachable, answering 503 when it
+// isn't so load balancers stop routing here. With a health monitor running it
+// reports the monitor's last result; otherwise it pings.
+func (s *Store) healthCheck(c *fiber.Ctx) error {
+	if s.health != nil {
+		if !s.health.healthy.Load() {
+			return c.Status(503).JSON(fiber.Map{"status": "unavailable", "error": s.health.lastErr.Load()})
+		}
+		return c.JSON(fiber.Map{"status": "ok"})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	if err := pingMongo(ctx, s.client); err != nil {
+		loggerFrom(c).Warn("Health check failed.", zap.Error(err))
+		return c.Status(503).JSON(fiber.Map{"status": "unavailable", "error": err.Error()})
+	}
+
+	return c.JSON(fiber.Map{"status": "ok"})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// ensureIndexes creates the indexes the API's queries rely on. Creating an
+// index that already exists with the same definition is a no-op, so this is
+// safe to run on every startup. The errors of every collection that failed are
+// returned together.
+func (s *Store) ensureIndexes(ctx context.Context, logger *zap.Logger) error {
+	indexes := map[*mongo.Collection][]mongo.IndexModel{
+		s.customers: {
+			{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "email", Value: "text"}}},
+		},
+		s.products: {
+			// Sparse since only products synced from the supplier feed have a SKU
+			{Keys: bson.D{{Key: "sku", Value: 1}}, Options: options.Index().SetUnique(true).SetSparse(true)},
+			{Keys: bson.D{{Key: "name", Value: "text"}, {Key: "category", Value: "text"}, {Key: "description", Value: "text"}}},
+		},
+		s.orders: {
+			{Keys: bson.D{{Key: "customer_id", Value: 1}}},
+			{Keys: bson.D{{Key: "status", Value: 1}}},
+		},
+	}
+
+	// A failure on one collection doesn't stop the others getting theirs
+	var errs []error
+	for coll, models := range indexes {
+		names, err := coll.Indexes().CreateMany(ctx, models)
+		if err != nil {
+			logger.Error("Failed to create indexes.", zap.String("collection", coll.Name()), zap.Error(err))
+			errs = append(errs, fmt.Errorf("%s: %w", coll.Name(), err))
+			continue
+		}
+		logger.Info("Ensured indexes.", zap.String("collection", coll.Name()), zap.Strings("indexes", names))
+	}
+
+	return errors.Join(errs...)
+}
+
+// listIndexes responds with the definitions of the indexes on the collection
+// in the path. It must be routed behind requireAdmin.
+func (s *Store) listIndexes(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	coll := s.collection(c.Params("collection"))
+	if coll == nil {
+		return errorResponse(c, 404, "Collection not found", nil)
+	}
+
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return errorResponse(c, 500, "Error listing indexes", err)
+	}
+	defer cursor.Close(ctx)
+
+	indexes, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error listing indexes", err)
+	}
+
+	return respond(c, normalizeDocs(indexes), fiber.Map{"count": len(indexes)})
+}
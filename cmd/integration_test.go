@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// The integration tests run against the MongoDB at MONGODB_TEST_URL when it
+// is set, and otherwise start one in a container, shared by all tests. Each
+// test gets a database of its own. Without either they are skipped.
+var (
+	testMongoOnce      sync.Once
+	testMongoClient    *mongo.Client
+	testMongoContainer *mongodb.MongoDBContainer
+	testMongoErr       error
+)
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	if testMongoClient != nil {
+		testMongoClient.Disconnect(context.Background())
+	}
+	if testMongoContainer != nil {
+		testMongoContainer.Terminate(context.Background())
+	}
+	os.Exit(code)
+}
+
+// testClient returns the client of the shared test MongoDB, starting it on
+// first use.
+func testClient(t *testing.T) *mongo.Client {
+	t.Helper()
+
+	url := os.Getenv("MONGODB_TEST_URL")
+	if url == "" {
+		testcontainers.SkipIfProviderIsNotHealthy(t)
+	}
+
+	testMongoOnce.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+
+		if url == "" {
+			// A replica set so the transactional handlers work too
+			testMongoContainer, testMongoErr = mongodb.Run(ctx, "mongo:7", mongodb.WithReplicaSet("rs0"))
+			if testMongoErr != nil {
+				return
+			}
+			if url, testMongoErr = testMongoContainer.ConnectionString(ctx); testMongoErr != nil {
+				return
+			}
+		}
+		// connectAndPing takes its connect timeout from opts, and the URI
+		// only sets one when it has connectTimeoutMS
+		opts := options.Client().ApplyURI(url).SetConnectTimeout(30 * time.Second)
+		testMongoClient, testMongoErr = connectAndPing(opts, 30*time.Second)
+	})
+	if testMongoErr != nil {
+		t.Fatalf("starting test MongoDB: %v", testMongoErr)
+	}
+	return testMongoClient
+}
+
+var unsafeDBChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// newTestStore returns a Store backed by a fresh database for t, dropped when
+// the test ends, and an app serving its routes.
+func newTestStore(t *testing.T) (*Store, *fiber.App) {
+	t.Helper()
+
+	client := testClient(t)
+	requestTimeout = 10 * time.Second
+	healthCheckTimeout = 5 * time.Second
+
+	// Database names are capped at 63 bytes
+	name := "test_" + unsafeDBChars.ReplaceAllString(t.Name(), "_")
+	if len(name) > 63 {
+		name = name[:63]
+	}
+	store := NewStore(client, name)
+	t.Cleanup(func() {
+		store.database.Drop(context.Background())
+	})
+
+	app := fiber.New()
+	store.registerRoutes(app)
+	return store, app
+}
+
+// insert inserts docs into coll, failing the test on error.
+func insert(t *testing.T, coll *mongo.Collection, docs ...interface{}) {
+	t.Helper()
+	if _, err := coll.InsertMany(context.Background(), docs); err != nil {
+		t.Fatalf("seeding %s: %v", coll.Name(), err)
+	}
+}
+
+// request sends a request to app, with body as JSON when it isn't nil, and
+// returns the response status and decoded JSON body.
+func request(t *testing.T, app *fiber.App, method, path string, body interface{}) (int, map[string]interface{}) {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("encoding request body: %v", err)
+		}
+		reader = strings.NewReader(string(encoded))
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading response of %s %s: %v", method, path, err)
+	}
+	var decoded map[string]interface{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			t.Fatalf("decoding response of %s %s: %v: %s", method, path, err, raw)
+		}
+	}
+	return resp.StatusCode, decoded
+}
+
+// seedCustomers inserts a few customers and returns them by name.
+func seedCustomers(t *testing.T, store *Store) map[string]Customer {
+	t.Helper()
+
+	created := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	customers := map[string]Customer{}
+	var docs []interface{}
+	for i, name := range []string{"Ada", "Grace", "Linus"} {
+		customer := Customer{
+			ID:        primitive.NewObjectID(),
+			Name:      name,
+			Email:     strings.ToLower(name) + "@example.com",
+			Version:   1,
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}
+		customers[name] = customer
+		docs = append(docs, customer)
+	}
+	insert(t, store.customers, docs...)
+	return customers
+}
+
+func TestGetAllCustomers(t *testing.T) {
+	store, app := newTestStore(t)
+	seedCustomers(t, store)
+
+	status, body := request(t, app, http.MethodGet, "/api/customers?sort=name", nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+
+	data, _ := body["data"].([]interface{})
+	var names []string
+	for _, doc := range data {
+		names = append(names, doc.(map[string]interface{})["name"].(string))
+	}
+	if got, want := strings.Join(names, ","), "Ada,Grace,Linus"; got != want {
+		t.Errorf("names = %s, want %s", got, want)
+	}
+
+	meta, _ := body["meta"].(map[string]interface{})
+	if meta["total"] != float64(3) || meta["count"] != float64(3) {
+		t.Errorf("meta = %v, want a total and count of 3", meta)
+	}
+}
+
+func TestGetAllCustomersFilteredAndPaged(t *testing.T) {
+	store, app := newTestStore(t)
+	seedCustomers(t, store)
+
+	status, body := request(t, app, http.MethodGet, "/api/customers?email=grace@example.com", nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+	if data, _ := body["data"].([]interface{}); len(data) != 1 {
+		t.Errorf("got %d customers for the email filter, want 1", len(data))
+	}
+
+	status, body = request(t, app, http.MethodGet, "/api/customers?sort=name&limit=2&page=2", nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+	data, _ := body["data"].([]interface{})
+	if len(data) != 1 || data[0].(map[string]interface{})["name"] != "Linus" {
+		t.Errorf("page 2 = %v, want only Linus", data)
+	}
+	meta, _ := body["meta"].(map[string]interface{})
+	if meta["total_pages"] != float64(2) || meta["has_next"] != false || meta["has_prev"] != true {
+		t.Errorf("meta = %v, want the last of 2 pages", meta)
+	}
+}
+
+func TestGetCustomerByID(t *testing.T) {
+	store, app := newTestStore(t)
+	customers := seedCustomers(t, store)
+	grace := customers["Grace"]
+
+	status, body := request(t, app, http.MethodGet, "/api/customers/"+grace.ID.Hex(), nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+	data, _ := body["data"].(map[string]interface{})
+	if data["_id"] != grace.ID.Hex() || data["email"] != grace.Email {
+		t.Errorf("customer = %v, want %s", data, grace.Email)
+	}
+
+	status, _ = request(t, app, http.MethodGet, "/api/customers/not-an-id", nil)
+	if status != http.StatusBadRequest {
+		t.Errorf("status for an invalid ID = %d, want 400", status)
+	}
+}
+
+func TestGetCustomerByLegacyStringID(t *testing.T) {
+	store, app := newTestStore(t)
+	insert(t, store.customers, bson.M{"_id": "legacy-42", "name": "Old", "email": "old@example.com"})
+
+	status, body := request(t, app, http.MethodGet, "/api/customers/legacy-42", nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+}
+
+// TestByIDNotFound checks that every handler addressing a document by ID
+// answers 404 for a well-formed ID that matches nothing.
+func TestByIDNotFound(t *testing.T) {
+	_, app := newTestStore(t)
+	missing := primitive.NewObjectID().Hex()
+
+	customer := map[string]interface{}{"name": "Nobody", "email": "nobody@example.com", "version": 1}
+	product := map[string]interface{}{"name": "Nothing", "price": 1}
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+	}{
+		{http.MethodGet, "/api/customers/" + missing, nil},
+		{http.MethodGet, "/api/customers/" + missing + "/orders", nil},
+		{http.MethodPut, "/api/customers/" + missing, customer},
+		{http.MethodPatch, "/api/customers/" + missing, map[string]interface{}{"name": "Nobody", "version": 1}},
+		{http.MethodDelete, "/api/customers/" + missing, nil},
+		{http.MethodGet, "/api/products/" + missing, nil},
+		{http.MethodPut, "/api/products/" + missing, product},
+		{http.MethodPatch, "/api/products/" + missing, product},
+		{http.MethodGet, "/api/orders/" + missing, nil},
+		{http.MethodGet, "/api/orders/" + missing + "/items", nil},
+		{http.MethodGet, "/api/orders/" + missing + "/products", nil},
+		{http.MethodPatch, "/api/orders/" + missing + "/status", map[string]interface{}{"status": "paid"}},
+		{http.MethodPatch, "/api/orders/" + missing + "/items/" + missing, map[string]interface{}{"quantity": 1}},
+		{http.MethodDelete, "/api/orders/" + missing, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			status, body := request(t, app, tt.method, tt.path, tt.body)
+			if status != http.StatusNotFound {
+				t.Errorf("status = %d, want 404: %v", status, body)
+			}
+		})
+	}
+}
+
+// seedOrders inserts an order for customer per total, each with a single item
+// worth that total but no stored total, and returns them in the same order.
+func seedOrders(t *testing.T, store *Store, customer Customer, totals ...float64) []Order {
+	t.Helper()
+
+	created := time.Date(2024, 2, 3, 10, 0, 0, 0, time.UTC)
+	var orders []Order
+	var docs []interface{}
+	for i, total := range totals {
+		order := Order{
+			ID:         primitive.NewObjectID(),
+			CustomerID: customer.ID,
+			Items: []OrderItem{{
+				ID:        primitive.NewObjectID(),
+				ProductID: primitive.NewObjectID(),
+				Quantity:  2,
+				Price:     total / 2,
+			}},
+			Status:    "pending",
+			CreatedAt: created.Add(time.Duration(i) * time.Hour),
+		}
+		orders = append(orders, order)
+		docs = append(docs, order)
+	}
+	insert(t, store.orders, docs...)
+	return orders
+}
+
+func TestGetAllOrdersComputeTotalsPaged(t *testing.T) {
+	store, app := newTestStore(t)
+	customers := seedCustomers(t, store)
+	seedOrders(t, store, customers["Ada"], 10, 30, 20)
+
+	status, body := request(t, app, http.MethodGet, "/api/orders?computeTotals=true&expand=customer&sort=-total&limit=2", nil)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %v", status, body)
+	}
+
+	data, _ := body["data"].([]interface{})
+	var totals []float64
+	for _, doc := range data {
+		order := doc.(map[string]interface{})
+		totals = append(totals, order["total"].(float64))
+		if customer, _ := order["customer"].(map[string]interface{}); customer["name"] != "Ada" {
+			t.Errorf("customer = %v, want Ada", order["customer"])
+		}
+	}
+	if len(totals) != 2 || totals[0] != 30 || totals[1] != 20 {
+		t.Errorf("totals = %v, want [30 20]", totals)
+	}
+
+	meta, _ := body["meta"].(map[string]interface{})
+	if meta["total"] != float64(3) || meta["count"] != float64(2) || meta["total_pages"] != float64(2) {
+		t.Errorf("meta = %v, want 2 of 3 orders over 2 pages", meta)
+	}
+}
+
+func TestUpdateSoftDeletedCustomer(t *testing.T) {
+	store, app := newTestStore(t)
+	id := primitive.NewObjectID()
+	insert(t, store.customers, bson.M{
+		"_id": id, "name": "Gone", "email": "gone@example.com",
+		"version": 1, "deleted_at": time.Now().UTC(),
+	})
+
+	path := "/api/customers/" + id.Hex()
+	replacement := map[string]interface{}{"name": "Back", "email": "back@example.com", "version": 1}
+	if status, body := request(t, app, http.MethodPut, path, replacement); status != http.StatusNotFound {
+		t.Errorf("PUT status = %d, want 404: %v", status, body)
+	}
+	patch := map[string]interface{}{"name": "Back", "version": 1}
+	if status, body := request(t, app, http.MethodPatch, path, patch); status != http.StatusNotFound {
+		t.Errorf("PATCH status = %d, want 404: %v", status, body)
+	}
+
+	var customer Customer
+	if err := store.customers.FindOne(context.Background(), bson.M{"_id": id}).Decode(&customer); err != nil {
+		t.Fatalf("finding customer: %v", err)
+	}
+	if customer.Name != "Gone" || customer.Version != 1 {
+		t.Errorf("soft-deleted customer was updated: %+v", customer)
+	}
+}
+
+// TestCreateOrderAgreesWithValidate checks that createOrder rejects the
+// orders validateOrder reports issues with, and takes no stock for them.
+func TestCreateOrderAgreesWithValidate(t *testing.T) {
+	store, app := newTestStore(t)
+	customer := seedCustomers(t, store)["Ada"]
+	live, deleted := primitive.NewObjectID(), primitive.NewObjectID()
+	insert(t, store.products,
+		bson.M{"_id": live, "name": "Lamp", "price": 25.0, "stock": 5},
+		bson.M{"_id": deleted, "name": "Old lamp", "price": 25.0, "stock": 5, "deleted_at": time.Now().UTC()},
+	)
+
+	order := func(productID primitive.ObjectID, price float64) map[string]interface{} {
+		return map[string]interface{}{
+			"customer_id": customer.ID.Hex(),
+			"items": []map[string]interface{}{
+				{"product_id": productID.Hex(), "quantity": 1, "price": price},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		order  map[string]interface{}
+		status int
+	}{
+		{"deleted product", order(deleted, 25), http.StatusBadRequest},
+		{"price mismatch", order(live, 20), http.StatusConflict},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := request(t, app, http.MethodPost, "/api/orders/validate", tt.order)
+			if status != http.StatusOK || body["valid"] != false {
+				t.Errorf("validate = %d %v, want an invalid order", status, body)
+			}
+			if status, body := request(t, app, http.MethodPost, "/api/orders", tt.order); status != tt.status {
+				t.Errorf("create status = %d, want %d: %v", status, tt.status, body)
+			}
+		})
+	}
+
+	for _, id := range []primitive.ObjectID{live, deleted} {
+		var product struct {
+			Name  string `bson:"name"`
+			Stock int    `bson:"stock"`
+		}
+		if err := store.products.FindOne(context.Background(), bson.M{"_id": id}).Decode(&product); err != nil {
+			t.Fatalf("finding product: %v", err)
+		}
+		if product.Stock != 5 {
+			t.Errorf("stock of %s = %d, want 5", product.Name, product.Stock)
+		}
+	}
+
+	status, body := request(t, app, http.MethodPost, "/api/orders", order(live, 25))
+	if status != http.StatusCreated {
+		t.Errorf("create status for a valid order = %d, want 201: %v", status, body)
+	}
+}
+
+func TestDeleteOrder(t *testing.T) {
+	store, app := newTestStore(t)
+	customer := seedCustomers(t, store)["Ada"]
+	orders := seedOrders(t, store, customer, 10, 20)
+	path := "/api/orders/" + orders[0].ID.Hex()
+
+	if status, body := request(t, app, http.MethodDelete, path, nil); status != http.StatusNoContent {
+		t.Fatalf("status = %d, want 204: %v", status, body)
+	}
+	count, err := store.orders.CountDocuments(context.Background(), bson.M{"_id": orders[0].ID})
+	if err != nil {
+		t.Fatalf("counting orders: %v", err)
+	}
+	if count != 0 {
+		t.Error("deleted order is still stored")
+	}
+	if status, _ := request(t, app, http.MethodGet, "/api/orders/"+orders[1].ID.Hex(), nil); status != http.StatusOK {
+		t.Errorf("status of the other order = %d, want 200", status)
+	}
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+	}{
+		{"already deleted", path, http.StatusNotFound},
+		{"never existed", "/api/orders/" + primitive.NewObjectID().Hex(), http.StatusNotFound},
+		{"invalid ID", "/api/orders/not-an-id", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if status, body := request(t, app, http.MethodDelete, tt.path, nil); status != tt.status {
+				t.Errorf("status = %d, want %d: %v", status, tt.status, body)
+			}
+		})
+	}
+}
+
+// TestPatchCantSoftDelete checks that deleted_at sent in an update is ignored,
+// so documents can only be soft-deleted by deleting them.
+func TestPatchCantSoftDelete(t *testing.T) {
+	store, app := newTestStore(t)
+	customer := seedCustomers(t, store)["Ada"]
+	productID := primitive.NewObjectID()
+	insert(t, store.products, bson.M{"_id": productID, "name": "Lamp", "price": 25.0, "stock": 5})
+
+	tests := []struct {
+		path string
+		body map[string]interface{}
+	}{
+		{"/api/customers/" + customer.ID.Hex(), map[string]interface{}{"name": "Ada L", "deleted_at": "x", "version": 1}},
+		{"/api/products/" + productID.Hex(), map[string]interface{}{"name": "Desk lamp", "deleted_at": "x"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if status, body := request(t, app, http.MethodPatch, tt.path, tt.body); status != http.StatusOK {
+				t.Fatalf("PATCH status = %d, want 200: %v", status, body)
+			}
+			status, body := request(t, app, http.MethodGet, tt.path, nil)
+			if status != http.StatusOK {
+				t.Fatalf("GET status = %d, want 200: %v", status, body)
+			}
+			if data, _ := body["data"].(map[string]interface{}); data["deleted_at"] != nil {
+				t.Errorf("deleted_at = %v, want it unset", data["deleted_at"])
+			}
+		})
+	}
+}
+
+// TestListCollections checks that the product and order lists are served by
+// getAll like the customer one, and that unknown collections are 404.
+func TestListCollections(t *testing.T) {
+	store, app := newTestStore(t)
+	customer := seedCustomers(t, store)["Ada"]
+	seedOrders(t, store, customer, 10, 20)
+	insert(t, store.products, bson.M{"name": "Lamp", "price": 25.0, "stock": 5})
+
+	tests := []struct {
+		path   string
+		status int
+		count  int
+	}{
+		{"/api/products", http.StatusOK, 1},
+		{"/api/orders", http.StatusOK, 2},
+		{"/api/widgets", http.StatusNotFound, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			status, body := request(t, app, http.MethodGet, tt.path, nil)
+			if status != tt.status {
+				t.Fatalf("status = %d, want %d: %v", status, tt.status, body)
+			}
+			if data, _ := body["data"].([]interface{}); len(data) != tt.count {
+				t.Errorf("got %d documents, want %d", len(data), tt.count)
+			}
+		})
+	}
+}
+
+// TestListPagePastEnd checks that pages past the end, however far, are empty
+// pages rather than errors.
+func TestListPagePastEnd(t *testing.T) {
+	store, app := newTestStore(t)
+	seedCustomers(t, store)
+
+	for _, page := range []string{"3", "100000000000000000", "9223372036854775807"} {
+		t.Run(page, func(t *testing.T) {
+			status, body := request(t, app, http.MethodGet, "/api/customers?limit=100&page="+page, nil)
+			if status != http.StatusOK {
+				t.Fatalf("status = %d, want 200: %v", status, body)
+			}
+			if data, _ := body["data"].([]interface{}); len(data) != 0 {
+				t.Errorf("got %d customers, want none", len(data))
+			}
+			meta, _ := body["meta"].(map[string]interface{})
+			if meta["total"] != float64(3) || meta["total_pages"] != float64(1) || meta["has_next"] != false {
+				t.Errorf("meta = %v, want the 3 customers on 1 page", meta)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// listSpec describes how getAll lists one of the API collections.
+type listSpec struct {
+	collection func(s *Store) *mongo.Collection
+	// filter builds the filter from the request's query parameters
+	filter func(c *fiber.Ctx) (bson.M, error)
+	// fields are the fields clients may filter, sort and project by
+	fields fieldPolicy
+	// list, when set, responds in place of listDocuments, for collections
+	// with list modes of their own
+	list func(s *Store, c *fiber.Ctx, filter bson.M) error
+}
+
+// listSpecs are the collections getAll can list, keyed by the name used in
+// the route.
+var listSpecs = map[string]listSpec{
+	"customers": {
+		collection: func(s *Store) *mongo.Collection { return s.customers },
+		filter:     customerFilter,
+		fields:     customerPolicy,
+		list:       (*Store).listCustomers,
+	},
+	"products": {
+		collection: func(s *Store) *mongo.Collection { return s.products },
+		filter:     productFilter,
+		fields:     productPolicy,
+		list:       (*Store).listProducts,
+	},
+	"orders": {
+		collection: func(s *Store) *mongo.Collection { return s.orders },
+		filter:     orderFilter,
+		fields:     orderPolicy,
+		list:       (*Store).listOrders,
+	},
+}
+
+// getAll responds with a page of the collection named in the path, filtered,
+// sorted and projected by the query parameters its listSpec allows. It serves
+// the customer, product and order lists.
+func (s *Store) getAll(c *fiber.Ctx) error {
+	spec, ok := listSpecs[c.Params("collection")]
+	if !ok {
+		return errorResponse(c, 404, "Collection not found", nil)
+	}
+
+	filter, err := spec.filter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	if spec.list != nil {
+		return spec.list(s, c, filter)
+	}
+	return s.listDocuments(c, spec.collection(s), filter, spec.fields)
+}
+
+// listDocuments responds with the page of the documents in coll matching
+// filter that the page and limit parameters select, sorted by the sort
+// parameter and projected by the fields parameter, both restricted to the
+// fields policy.
+// ?collation= makes the sort and filter case-insensitive for a locale, and
+// ?search= runs a text search, best matches first.
+// Every list endpoint goes through here so they all page the same way.
+func (s *Store) listDocuments(c *fiber.Ctx, coll *mongo.Collection, filter bson.M, fields fieldPolicy) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	excludeDeleted(c, filter)
+
+	page, limit := parsePagination(c, s.pageSize(coll.Name()))
+	projection, err := parseProjection(c.Query("fields"), fields.project)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid fields parameter", err)
+	}
+	sort, err := parseSort(c.Query("sort"), fields.sort)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid sort parameter", err)
+	}
+
+	collation, err := parseCollation(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid collation parameter", err)
+	}
+
+	// Text search ranks by relevance, returned as each document's score
+	search := strings.TrimSpace(c.Query("search"))
+	if search != "" {
+		filter["$text"] = bson.M{"$search": search}
+		textScore := bson.M{"$meta": "textScore"}
+		if projection == nil {
+			projection = bson.M{}
+		}
+		projection["score"] = textScore
+		sort = append(bson.D{{Key: "score", Value: textScore}}, sort...)
+	}
+
+	// The count uses the collation too so it agrees with the page on matches
+	countOpts := options.Count()
+	if collation != nil {
+		countOpts.SetCollation(collation)
+	}
+	total, err := coll.CountDocuments(ctx, filter, countOpts)
+	if err != nil {
+		if search != "" && isIndexNotFound(err) {
+			return errorResponse(c, 400, "Text search is not available for "+coll.Name(), nil)
+		}
+		return errorResponse(c, 500, "Error counting "+coll.Name(), err)
+	}
+
+	opts := options.Find().SetSkip((page - 1) * limit).SetLimit(limit)
+	if collation != nil {
+		opts.SetCollation(collation)
+	}
+	if len(sort) > 0 {
+		opts.SetSort(sort)
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding "+coll.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	docs, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding "+coll.Name(), err)
+	}
+
+	setPaginationHeaders(c, total, page, limit)
+	meta := buildPagination(total, page, limit)
+	meta["count"] = len(docs)
+	return respond(c, normalizeDocs(docs), meta)
+}
+
+// isIndexNotFound reports whether err is the server saying a query needs an
+// index that doesn't exist, as $text does without a text index.
+func isIndexNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(27)
+}
+
+// decodeAll decodes the documents remaining in cursor like cursor.All, except
+// that a document that fails to decode is logged by _id and skipped rather
+// than failing the whole list. When any are skipped a Warning header tells
+// the client the results are partial. Errors from the cursor itself are
+// still returned.
+func decodeAll(ctx context.Context, c *fiber.Ctx, cursor *mongo.Cursor) ([]bson.M, error) {
+	docs := []bson.M{}
+	skipped := 0
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			skipped++
+			loggerFrom(c).Warn("Skipped a document that failed to decode.",
+				zap.String("id", cursor.Current.Lookup("_id").String()),
+				zap.Error(err))
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if skipped > 0 {
+		c.Set("Warning", fmt.Sprintf(`199 - "%d documents could not be decoded and were left out"`, skipped))
+	}
+	return docs, nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// changeEvent is the part of a change stream event the live endpoints use.
+type changeEvent struct {
+	OperationType string `bson:"operationType"`
+	FullDocument  bson.M `bson:"fullDocument"`
+}
+
+// requireWebSocket answers 426 to requests to a websocket route that aren't
+// websocket upgrades.
+func requireWebSocket(c *fiber.Ctx) error {
+	if !websocket.IsWebSocketUpgrade(c) {
+		return errorResponse(c, 426, "Websocket upgrade required", nil)
+	}
+	return c.Next()
+}
+
+// resumeToken returns the token a client can pass back as resumeAfter to pick
+// up the stream after the current event.
+func resumeToken(stream *mongo.ChangeStream) string {
+	token, _ := stream.ResumeToken().Lookup("_data").StringValueOK()
+	return token
+}
+
+// liveOrders upgrades the request to a websocket served by watchOrders.
+func (s *Store) liveOrders(c *fiber.Ctx) error {
+	return websocket.New(s.watchOrders)(c)
+}
+
+// watchOrders pushes inserted, updated and replaced orders to a websocket
+// client as they happen. A client reconnecting after a drop can pass the
+// resume_token of the last event it saw as ?resumeAfter= to receive the
+// events it missed. The change stream is closed when the client disconnects.
+func (s *Store) watchOrders(conn *websocket.Conn) {
+	logger, ok := conn.Locals("logger").(*zap.Logger)
+	if !ok {
+		logger = zap.NewNop()
+	}
+
+	// The stream lives as long as the connection rather than a single
+	// request, so it isn't bounded by the request timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"operationType": bson.M{"$in": bson.A{"insert", "update", "replace"}},
+		}}},
+	}
+	opts := options.ChangeStream().SetFullDocument(options.UpdateLookup)
+	if token := conn.Query("resumeAfter"); token != "" {
+		opts.SetResumeAfter(bson.M{"_data": token})
+	}
+
+	stream, err := s.orders.Watch(ctx, pipeline, opts)
+	if err != nil {
+		logger.Error("Failed to watch orders.", zap.Error(err))
+		conn.WriteJSON(fiber.Map{"error": "Error watching orders", "status": 500})
+		return
+	}
+	defer stream.Close(context.Background())
+
+	// Clients only ever send close frames, so reading just waits for the
+	// disconnect and stops the stream through ctx
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for stream.Next(ctx) {
+		var event changeEvent
+		if err := stream.Decode(&event); err != nil {
+			logger.Error("Failed to decode order change.", zap.Error(err))
+			continue
+		}
+
+		err := conn.WriteJSON(fiber.Map{
+			"operation":    event.OperationType,
+			"order":        normalizeDoc(event.FullDocument),
+			"resume_token": resumeToken(stream),
+		})
+		if err != nil {
+			return
+		}
+	}
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		logger.Error("Order change stream failed.", zap.Error(err))
+	}
+}
+
+// sseKeepAlive is how often streamCustomers writes a comment while no events
+// arrive, both to keep proxies from timing the connection out and to notice
+// clients that went away.
+const sseKeepAlive = 15 * time.Second
+
+// streamCustomers sends a server-sent event for every customer inserted. Each
+// event's id is its resume token, so browsers reconnecting with Last-Event-ID
+// pick up where they left off. The stream ends when the client disconnects or
+// the server shuts down.
+func (s *Store) streamCustomers(c *fiber.Ctx) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"operationType": "insert"}}},
+	}
+	opts := options.ChangeStream()
+	if token := c.Get("Last-Event-ID"); token != "" {
+		opts.SetResumeAfter(bson.M{"_data": token})
+	}
+
+	// The stream lives as long as the connection rather than a single
+	// request; it is stopped when the server starts shutting down
+	ctx, cancel := context.WithCancel(context.Background())
+	shutdown := c.Context().Done()
+	go func() {
+		select {
+		case <-shutdown:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	stream, err := s.customers.Watch(ctx, pipeline, opts)
+	if err != nil {
+		cancel()
+		return errorResponse(c, 500, "Error watching customers", err)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	streamBody(c, func(w *bodyStream) {
+		defer cancel()
+		defer stream.Close(context.Background())
+
+		lastWrite := time.Now()
+		for {
+			if !stream.TryNext(ctx) {
+				if err := stream.Err(); err != nil {
+					if ctx.Err() == nil {
+						w.logger.Error("Customer change stream failed.", zap.Error(err))
+					}
+					return
+				}
+				if time.Since(lastWrite) < sseKeepAlive {
+					continue
+				}
+				fmt.Fprint(w, ": keep-alive\n\n")
+				if !w.flush() {
+					return
+				}
+				lastWrite = time.Now()
+				continue
+			}
+
+			var event changeEvent
+			if err := stream.Decode(&event); err != nil {
+				w.logger.Error("Failed to decode customer change.", zap.Error(err))
+				continue
+			}
+			data, err := json.Marshal(normalizeDoc(event.FullDocument))
+			if err != nil {
+				w.logger.Error("Failed to encode customer change.", zap.Error(err))
+				continue
+			}
+
+			fmt.Fprintf(w, "id: %s\ndata: %s\n\n", resumeToken(stream), data)
+			if !w.flush() {
+				return
+			}
+			lastWrite = time.Now()
+		}
+	})
+
+	return nil
+}
@@ -2,35 +2,76 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"mongodb-practice/config"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
 	fiberLogger "github.com/gofiber/fiber/v2/middleware/logger"
+	fiberRecover "github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-var client *mongo.Client
-var customerCollection *mongo.Collection
-var productCollection *mongo.Collection
-var orderCollection *mongo.Collection
+const disconnectTimeout = 5 * time.Second
+
+// compressionLevels maps the COMPRESSION_LEVEL names to Fiber's levels.
+var compressionLevels = map[string]compress.Level{
+	"disabled": compress.LevelDisabled,
+	"default":  compress.LevelDefault,
+	"speed":    compress.LevelBestSpeed,
+	"best":     compress.LevelBestCompression,
+}
+
+var healthCheckTimeout time.Duration
+var requestTimeout time.Duration
 
 func main() {
-	// Setup zap logger
-	logger, err := zap.NewProduction()
+	// Setup zap logger, at info level until the configured level is known
+	logLevel := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	logConfig := zap.NewProductionConfig()
+	logConfig.Level = logLevel
+	logger, err := logConfig.Build()
 	if err != nil {
 		fmt.Printf("Failed to set up zap logger: %v\n", err)
 		os.Exit(1)
 	}
 	defer logger.Sync() // Flushes buffer, if any
 
-	config.LoadEnv()
+	if err := config.LoadEnv(); err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Fatal("Failed to load .env file.", zap.Error(err))
+		}
+		logger.Info("No .env file found, using the environment as is.")
+	}
+
+	if level, err := zapcore.ParseLevel(config.GetLogLevel()); err != nil {
+		logger.Warn("Invalid log level, using info.", zap.String("log_level", config.GetLogLevel()))
+	} else {
+		logLevel.SetLevel(level)
+	}
+
+	port := config.GetServerPort()
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		logger.Fatal("Invalid server port.", zap.String("port", port))
+	}
 
 	mongoURI := config.GetMongoDB_URL()
 	if mongoURI == "" {
@@ -41,192 +82,317 @@ func main() {
 	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
 	opts := options.Client().ApplyURI(mongoURI).SetServerAPIOptions(serverAPI)
 
-	// Create a client and connect to the server
-	client, err = mongo.Connect(context.TODO(), opts)
-	if err != nil {
-		logger.Fatal("Failed to connect to MongoDB.", zap.Error(err))
+	// The app name shows up in currentOp, the server logs and Atlas metrics,
+	// so connections can be traced back to this service
+	appName := config.GetAppName()
+	opts.SetAppName(appName)
+	logger.Info("MongoDB app name configured.", zap.String("app_name", appName))
+
+	maxPoolSize := config.GetMongoDB_MaxPoolSize()
+	minPoolSize := config.GetMongoDB_MinPoolSize()
+	if minPoolSize > maxPoolSize && maxPoolSize != 0 {
+		logger.Fatal("MongoDB minimum pool size exceeds the maximum.",
+			zap.Uint64("max_pool_size", maxPoolSize), zap.Uint64("min_pool_size", minPoolSize))
 	}
+	opts.SetMaxPoolSize(maxPoolSize).SetMinPoolSize(minPoolSize)
+	opts.SetMonitor(commandMonitor()).SetPoolMonitor(poolMonitor())
+	logger.Info("MongoDB connection pool configured.",
+		zap.Uint64("max_pool_size", maxPoolSize), zap.Uint64("min_pool_size", minPoolSize))
 
-	// Schedule a deferred disconnection
-	defer func() {
-		if err := client.Disconnect(context.TODO()); err != nil {
-			logger.Error("Failed to disconnect from MongoDB.", zap.Error(err))
+	readPrefMode, err := readpref.ModeFromString(config.GetMongoDB_ReadPreference())
+	if err != nil {
+		logger.Fatal("Invalid MongoDB read preference.", zap.Error(err))
+	}
+	readPref, err := readpref.New(readPrefMode)
+	if err != nil {
+		logger.Fatal("Invalid MongoDB read preference.", zap.Error(err))
+	}
+	opts.SetReadPreference(readPref)
+	logger.Info("MongoDB read preference configured.", zap.String("read_preference", readPrefMode.String()))
+
+	// Every collection inherits the client write concern, so this covers all
+	// the create, update and delete handlers
+	writeConcern := config.GetMongoDB_WriteConcern()
+	if writeConcern != "" {
+		wc, err := parseWriteConcern(writeConcern)
+		if err != nil {
+			logger.Fatal("Invalid MongoDB write concern.", zap.String("write_concern", writeConcern), zap.Error(err))
 		}
-	}()
-
-	// Set connection timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-
-	// Send a ping command to confirm connection
-	if err := client.Database("admin").RunCommand(ctx, bson.D{{"ping", 1}}).Err(); err != nil {
-		logger.Fatal("Failed to ping MongoDB.", zap.Error(err))
+		opts.SetWriteConcern(wc)
+		logger.Info("MongoDB write concern configured.", zap.Any("w", wc.W))
+	} else {
+		logger.Info("MongoDB write concern not set, using the server default.")
 	}
 
-	logger.Info("Successfully connected to MongoDB.")
-
-	customerCollection = client.Database("firstDB").Collection("customers")
-	productCollection = client.Database("firstDB").Collection("products")
-	orderCollection = client.Database("firstDB").Collection("orders")
+	tlsConfig, err := mongoTLSConfig(
+		config.GetMongoDB_TLSCAFile(),
+		config.GetMongoDB_TLSCertFile(),
+		config.GetMongoDB_TLSKeyFile(),
+		config.GetMongoDB_TLSInsecure())
+	if err != nil {
+		logger.Fatal("Invalid MongoDB TLS configuration.", zap.Error(err))
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+		if tlsConfig.InsecureSkipVerify {
+			logger.Warn("MongoDB TLS certificate verification is disabled.")
+		}
+		logger.Info("MongoDB TLS configured.", zap.Bool("client_certificate", len(tlsConfig.Certificates) > 0))
+	}
 
-	app := fiber.New()
+	connectTimeout := config.GetMongoDB_ConnectTimeout()
+	pingTimeout := config.GetMongoDB_PingTimeout()
+	opts.SetConnectTimeout(connectTimeout)
+	logger.Info("MongoDB timeouts configured.",
+		zap.Duration("connect_timeout", connectTimeout), zap.Duration("ping_timeout", pingTimeout))
 
-	// Middleware
-	app.Use(fiberLogger.New())
+	// Create a client and connect to the server, retrying while it starts up
+	attempts := config.GetMongoDB_ConnectAttempts()
+	baseDelay := config.GetMongoDB_ConnectBackoff()
+	client, err := connectWithRetry(logger, opts, attempts, baseDelay, pingTimeout)
+	if err != nil {
+		logger.Fatal("Failed to connect to MongoDB.", zap.Int("attempts", attempts), zap.Error(err))
+	}
 
-	// Serve static files
+	// Schedule a deferred disconnection, bounded so shutdown can't hang on it
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), disconnectTimeout)
+		defer cancel()
 
-	app.Get("/api/fields", listFields)
+		logger.Info("Disconnecting from MongoDB.")
+		if err := client.Disconnect(ctx); err != nil {
+			logger.Error("Failed to disconnect from MongoDB.", zap.Error(err))
+			return
+		}
+		logger.Info("Disconnected from MongoDB.")
+	}()
 
-	app.Get("/api/customers", getAllCustomers)
-	app.Get("/api/customers/:id", getCustomerByID)
-	app.Get("/api/products", getAllProducts)
-	app.Get("/api/products/:id", getProductByID)
-	app.Get("/api/orders", getAllOrders)
-	app.Get("/api/orders/:id", getOrderByID)
+	logger.Info("Successfully connected to MongoDB.")
 
-	if err := app.Listen(":8090"); err != nil {
-		logger.Fatal("Failed to start server.", zap.Error(err))
+	healthCheckTimeout = config.GetHealthCheckTimeout()
+	requestTimeout = config.GetRequestTimeout()
+
+	store := NewStore(client, config.GetMongoDB_Name())
+	store.softDelete = config.GetSoftDelete()
+	store.customerExportFields = config.GetCustomerExportFields()
+	store.cache = newResponseCache(config.GetCacheTTL())
+	store.defaultLimits = defaultLimits(logger)
+	store.addTenants(config.GetTenantDatabases())
+
+	// Stopped before the deferred disconnect so it doesn't report the
+	// shutdown as an outage
+	monitorCtx, stopMonitor := context.WithCancel(context.Background())
+	defer stopMonitor()
+	if interval := config.GetHealthCheckInterval(); interval > 0 {
+		store.health = newHealthMonitor()
+		go store.health.run(monitorCtx, logger, client, interval)
+		logger.Info("MongoDB health monitor started.", zap.Duration("interval", interval))
 	}
-}
 
-func listFields(c *fiber.Ctx) error {
-	// Get a list of all collection names in the database
-	collections, err := client.Database("firstDB").ListCollectionNames(context.Background(), bson.D{})
-	if err != nil {
-		return c.Status(500).SendString(err.Error())
+	indexCtx, cancelIndexes := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := store.ensureIndexes(indexCtx, logger); err != nil {
+		logger.Error("Not all indexes could be created.", zap.Error(err))
 	}
-
-	fields := make(map[string][]string)
-
-	for _, collection := range collections {
-		cursor, err := client.Database("firstDB").Collection(collection).Find(context.Background(), bson.D{})
-		if err != nil {
-			return c.Status(500).SendString(err.Error())
+	for name, tenant := range store.tenants {
+		if err := tenant.ensureIndexes(indexCtx, logger); err != nil {
+			logger.Error("Not all tenant indexes could be created.", zap.String("tenant", name), zap.Error(err))
 		}
-		defer cursor.Close(context.Background())
+	}
+	cancelIndexes()
 
-		var result bson.M
-		if cursor.Next(context.Background()) {
-			if err := cursor.Decode(&result); err != nil {
-				return c.Status(500).SendString(err.Error())
-			}
-			for key := range result {
-				fields[collection] = append(fields[collection], key)
-			}
+	if seedFile := config.GetSeedFile(); seedFile != "" {
+		seedCtx, cancelSeed := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := store.seed(seedCtx, logger, seedFile); err != nil {
+			logger.Error("Failed to seed the database.", zap.String("seed_file", seedFile), zap.Error(err))
 		}
+		cancelSeed()
 	}
 
-	return c.JSON(fields)
-}
+	// Larger bodies are refused with a 413 before they are read into memory
+	app := fiber.New(fiber.Config{
+		BodyLimit: config.GetMaxBodySize(),
+	})
 
-func getAllCustomers(c *fiber.Ctx) error {
-	cursor, err := customerCollection.Find(context.Background(), bson.D{})
-	if err != nil {
-		return c.Status(500).SendString(err.Error())
+	// Middleware
+	var inFlight atomic.Int64
+	app.Use(countInFlight(&inFlight))
+	// A panicking handler answers 500 instead of taking the process down
+	app.Use(fiberRecover.New())
+	app.Use(requestMetrics())
+	if level := compressionLevels[config.GetCompressionLevel()]; level != compress.LevelDisabled {
+		app.Use(compression(level))
 	}
-	defer cursor.Close(context.Background())
-
-	var customers []bson.M
-	if err = cursor.All(context.Background(), &customers); err != nil {
-		return c.Status(500).SendString(err.Error())
+	app.Use(requestid.New())
+	app.Use(requestLogger(logger))
+
+	// Structured access logs by default; the console format is easier to
+	// read when developing locally
+	if config.GetAccessLogFormat() == "console" {
+		app.Use(fiberLogger.New(fiberLogger.Config{
+			Format: "${time} | ${locals:requestid} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${error}\n",
+		}))
+	} else {
+		app.Use(accessLogger())
 	}
 
-	return c.JSON(customers)
-}
-
-func getCustomerByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var customer bson.M
-
-	// Use the ObjectID from the provided ID string
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return c.Status(400).SendString("Invalid ID format")
+	// Browsers only send credentials to explicitly allowed origins, so the
+	// development wildcard goes without them
+	corsOrigins := config.GetCORSOrigins()
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:     corsOrigins,
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE",
+		AllowCredentials: corsOrigins != "*",
+		// Browsers hide response headers from scripts unless listed here
+		ExposeHeaders: "Link,X-Total-Count",
+	}))
+
+	// A JWT secret takes over from the API key, since only tokens carry roles
+	if jwtSecret := config.GetJWTSecret(); jwtSecret != "" {
+		app.Use(requireAuth(jwtAuth([]byte(jwtSecret))))
+		app.Use(requireRole("admin"))
+		logger.Info("JWT authentication enabled.")
+	} else if apiKey := config.GetAPIKey(); apiKey != "" {
+		app.Use(requireAuth(apiKeyAuth(apiKey)))
+		logger.Info("API key authentication enabled.")
+	} else {
+		logger.Warn("Neither JWT_SECRET nor API_KEY is set, the API is served without authentication.")
 	}
 
-	filter := bson.M{"_id": objectID}
-
-	if err := customerCollection.FindOne(context.Background(), filter).Decode(&customer); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(404).SendString("Customer not found")
-		}
-		return c.Status(500).SendString("Error finding customer: " + err.Error())
+	if perMinute := config.GetRateLimitPerMinute(); perMinute > 0 {
+		app.Use(rateLimiter(perMinute))
+		logger.Info("Rate limiting enabled.", zap.Int("requests_per_minute", perMinute))
 	}
 
-	return c.JSON(customer)
-}
+	store.registerRoutes(app)
+
+	// GET reports the log level and PUT {"level": "debug"} changes it
+	app.All("/debug/loglevel", requireAdmin, adaptor.HTTPHandler(logLevel))
+
+	// Shut down gracefully on SIGINT/SIGTERM so in-flight requests finish
+	// and the deferred MongoDB disconnect gets to run
+	shutdownTimeout := config.GetShutdownTimeout()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+		sig := <-quit
+
+		logger.Info("Shutting down server.",
+			zap.String("signal", sig.String()),
+			zap.Int64("in_flight", inFlight.Load()),
+			zap.Duration("timeout", shutdownTimeout))
+		if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+			logger.Warn("Shutdown timed out before in-flight requests finished.",
+				zap.Int64("in_flight", inFlight.Load()),
+				zap.Error(err))
+		}
+	}()
 
-func getAllProducts(c *fiber.Ctx) error {
-	cursor, err := productCollection.Find(context.Background(), bson.D{})
-	if err != nil {
-		return c.Status(500).SendString(err.Error())
+	if err := app.Listen(fmt.Sprintf(":%s", port)); err != nil {
+		logger.Fatal("Failed to start server.", zap.Error(err))
 	}
-	defer cursor.Close(context.Background())
 
-	var products []bson.M
-	if err = cursor.All(context.Background(), &products); err != nil {
-		return c.Status(500).SendString(err.Error())
-	}
+	// Listen returns as soon as shutdown starts, so wait for in-flight
+	// requests to drain before MongoDB is disconnected from under them
+	<-shutdownDone
 
-	return c.JSON(products)
+	logger.Info("Server stopped.")
 }
 
-func getProductByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var product bson.M
+// defaultLimits reads the configured default page size of each collection,
+// falling back to the global DEFAULT_LIMIT and then to defaultLimit. Invalid
+// values stop startup.
+func defaultLimits(logger *zap.Logger) map[string]int64 {
+	global := int64(defaultLimit)
+	if raw := config.GetDefaultLimit(); raw != "" {
+		limit, err := parseDefaultLimit(raw)
+		if err != nil {
+			logger.Fatal("Invalid DEFAULT_LIMIT.", zap.Error(err))
+		}
+		global = limit
+	}
 
-	// Use the ObjectID from the provided ID string
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return c.Status(400).SendString("Invalid ID format")
+	limits := map[string]int64{}
+	for _, collection := range []string{"customers", "products", "orders"} {
+		limits[collection] = global
+		if raw := config.GetCollectionDefaultLimit(collection); raw != "" {
+			limit, err := parseDefaultLimit(raw)
+			if err != nil {
+				logger.Fatal("Invalid collection default limit.", zap.String("collection", collection), zap.Error(err))
+			}
+			limits[collection] = limit
+		}
 	}
 
-	filter := bson.M{"_id": objectID}
+	logger.Info("Default page sizes configured.", zap.Any("default_limits", limits))
+	return limits
+}
 
-	if err := productCollection.FindOne(context.Background(), filter).Decode(&product); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(404).SendString("Product not found")
+// connectWithRetry connects to MongoDB and pings it, retrying up to attempts
+// times with exponential backoff starting at baseDelay.
+func connectWithRetry(logger *zap.Logger, opts *options.ClientOptions, attempts int, baseDelay, pingTimeout time.Duration) (*mongo.Client, error) {
+	delay := baseDelay
+	for attempt := 1; ; attempt++ {
+		client, err := connectAndPing(opts, pingTimeout)
+		if err == nil {
+			return client, nil
+		}
+		if attempt >= attempts {
+			return nil, err
 		}
-		return c.Status(500).SendString("Error finding product: " + err.Error())
-	}
 
-	return c.JSON(product)
+		logger.Warn("Failed to connect to MongoDB, retrying.",
+			zap.Int("attempt", attempt),
+			zap.Int("max_attempts", attempts),
+			zap.Duration("retry_in", delay),
+			zap.Error(err))
+		time.Sleep(delay)
+		delay *= 2
+	}
 }
 
-func getAllOrders(c *fiber.Ctx) error {
-	cursor, err := orderCollection.Find(context.Background(), bson.D{})
+// connectAndPing makes a single attempt at connecting to MongoDB, bounded by
+// the connect timeout set on opts, confirming the connection with a ping
+// bounded by pingTimeout.
+func connectAndPing(opts *options.ClientOptions, pingTimeout time.Duration) (*mongo.Client, error) {
+	// Set connection timeout
+	ctx, cancel := context.WithTimeout(context.Background(), *opts.ConnectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
-		return c.Status(500).SendString(err.Error())
+		return nil, err
 	}
-	defer cursor.Close(context.Background())
 
-	var orders []bson.M
-	if err = cursor.All(context.Background(), &orders); err != nil {
-		return c.Status(500).SendString(err.Error())
+	// Send a ping command to confirm connection
+	pingCtx, cancelPing := context.WithTimeout(context.Background(), pingTimeout)
+	defer cancelPing()
+	if err := pingMongo(pingCtx, client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
 	}
 
-	return c.JSON(orders)
+	return client, nil
 }
 
-func getOrderByID(c *fiber.Ctx) error {
-	id := c.Params("id")
-	var order bson.M
-
-	// Use the ObjectID from the provided ID string
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err != nil {
-		return c.Status(400).SendString("Invalid ID format")
+// parseWriteConcern parses a write concern "w" value, either "majority" or a
+// number of nodes of at least 1. Unacknowledged writes (w=0) are rejected,
+// since the handlers report write results and errors they would never see.
+func parseWriteConcern(raw string) (*writeconcern.WriteConcern, error) {
+	if strings.EqualFold(raw, "majority") {
+		return writeconcern.Majority(), nil
 	}
 
-	filter := bson.M{"_id": objectID}
-
-	if err := orderCollection.FindOne(context.Background(), filter).Decode(&order); err != nil {
-		if err == mongo.ErrNoDocuments {
-			return c.Status(404).SendString("Order not found")
-		}
-		return c.Status(500).SendString("Error finding order: " + err.Error())
+	nodes, err := strconv.Atoi(raw)
+	if err != nil || nodes < 1 {
+		return nil, fmt.Errorf("write concern must be \"majority\" or a number of at least 1, got %q", raw)
 	}
+	return &writeconcern.WriteConcern{W: nodes}, nil
+}
 
-	return c.JSON(order)
+// pingMongo sends a ping command to confirm the server is reachable.
+func pingMongo(ctx context.Context, client *mongo.Client) error {
+	return client.Database("admin").RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err()
 }
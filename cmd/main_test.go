@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestParseWriteConcern(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantW   interface{}
+		wantErr bool
+	}{
+		{"majority", "majority", false},
+		{"MAJORITY", "majority", false},
+		{"1", 1, false},
+		{"3", 3, false},
+		{"0", nil, true},
+		{"-1", nil, true},
+		{"all", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			wc, err := parseWriteConcern(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseWriteConcern(%q) error = %v, want error %v", tt.raw, err, tt.wantErr)
+			}
+			if err == nil && wc.W != tt.wantW {
+				t.Errorf("parseWriteConcern(%q).W = %v, want %v", tt.raw, wc.W, tt.wantW)
+			}
+		})
+	}
+}
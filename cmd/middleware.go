@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+// countInFlight keeps n at the number of requests whose handlers are still
+// running, for reporting how many a shutdown is waiting on. It should be the
+// first middleware so it covers the whole chain.
+func countInFlight(n *atomic.Int64) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		n.Add(1)
+		defer n.Add(-1)
+		return c.Next()
+	}
+}
+
+// requestLogger stores a child of logger tagged with the request ID in the
+// request locals, so handler logs can be correlated with the access log.
+// It must run after the requestid middleware.
+func requestLogger(logger *zap.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id, _ := c.Locals("requestid").(string)
+		c.Locals("logger", logger.With(zap.String("request_id", id)))
+		return c.Next()
+	}
+}
+
+// loggerFrom returns the request-scoped logger set by requestLogger.
+func loggerFrom(c *fiber.Ctx) *zap.Logger {
+	if logger, ok := c.Locals("logger").(*zap.Logger); ok {
+		return logger
+	}
+	return zap.NewNop()
+}
+
+// accessLogger logs every request through the request-scoped logger with
+// structured fields. It must run after requestLogger.
+func accessLogger() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		loggerFrom(c).Info("Handled request.",
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.Int("status", responseStatus(c, err)),
+			zap.Duration("latency", time.Since(start)),
+			zap.String("ip", c.IP()))
+
+		return err
+	}
+}
+
+// responseStatus returns the status a request will be answered with once err,
+// as returned from c.Next(), has gone through the error handler.
+func responseStatus(c *fiber.Ctx, err error) int {
+	if err == nil {
+		return c.Response().StatusCode()
+	}
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return fiberErr.Code
+	}
+	return fiber.StatusInternalServerError
+}
+
+// publicPaths are served without authentication so probes and scrapers don't
+// need credentials.
+var publicPaths = map[string]bool{
+	"/healthz": true,
+	"/metrics": true,
+}
+
+// authenticator reports whether a request carries valid credentials.
+type authenticator func(c *fiber.Ctx) bool
+
+// requireAuth answers 401 to requests to anything but publicPaths that
+// authenticate rejects. It must run after the CORS middleware so preflight
+// requests, which never carry credentials, are answered first.
+func requireAuth(authenticate authenticator) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if publicPaths[c.Path()] || authenticate(c) {
+			return c.Next()
+		}
+		return errorResponse(c, 401, "Unauthorized", nil)
+	}
+}
+
+// apiKeyAuth accepts requests whose X-API-Key header is key, setting the
+// "apiKey" local so rateLimiter knows the header was checked.
+func apiKeyAuth(key string) authenticator {
+	return func(c *fiber.Ctx) bool {
+		// Constant time so the key can't be guessed byte by byte from timings
+		if subtle.ConstantTimeCompare([]byte(c.Get("X-API-Key")), []byte(key)) != 1 {
+			return false
+		}
+		c.Locals("apiKey", key)
+		return true
+	}
+}
+
+// authClaims are the JWT claims the API reads.
+type authClaims struct {
+	Roles []string `json:"roles"`
+	jwt.RegisteredClaims
+}
+
+// jwtAuth accepts requests with an "Authorization: Bearer" token signed with
+// secret using HS256, storing its claims in the "claims" local for
+// requireRole to check.
+func jwtAuth(secret []byte) authenticator {
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	keyFunc := func(*jwt.Token) (interface{}, error) { return secret, nil }
+
+	return func(c *fiber.Ctx) bool {
+		raw, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if !ok {
+			return false
+		}
+
+		var claims authClaims
+		if _, err := parser.ParseWithClaims(raw, &claims, keyFunc); err != nil {
+			loggerFrom(c).Debug("Rejected JWT.", zap.Error(err))
+			return false
+		}
+		c.Locals("claims", &claims)
+		return true
+	}
+}
+
+// readOnlyPosts are POST routes that only read, taking their input in the
+// body because it doesn't fit a query string.
+var readOnlyPosts = map[string]bool{
+	"/api/orders/validate": true,
+	"/api/products/batch":  true,
+}
+
+// requireRole answers 403 to write requests from callers whose JWT lacks
+// role. Reads, including readOnlyPosts, are allowed for any authenticated
+// caller. It must run after requireAuth with jwtAuth.
+func requireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		switch c.Method() {
+		case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions:
+			return c.Next()
+		case fiber.MethodPost:
+			if readOnlyPosts[c.Path()] {
+				return c.Next()
+			}
+		}
+		if publicPaths[c.Path()] || hasRole(c, role) {
+			return c.Next()
+		}
+		return errorResponse(c, 403, "Forbidden", nil)
+	}
+}
+
+// requireAdmin answers 403 to any request whose JWT lacks the admin role, for
+// routes that even reads must be guarded on. Without JWT authentication no
+// caller has roles, so these routes are closed.
+func requireAdmin(c *fiber.Ctx) error {
+	if !hasRole(c, "admin") {
+		return errorResponse(c, 403, "Forbidden", nil)
+	}
+	return c.Next()
+}
+
+// hasRole reports whether the request's JWT claims include role.
+func hasRole(c *fiber.Ctx, role string) bool {
+	claims, ok := c.Locals("claims").(*authClaims)
+	if !ok {
+		return false
+	}
+	for _, r := range claims.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter allows each client perMinute requests a minute to anything but
+// publicPaths, answering 429 with a Retry-After header past that. Clients are
+// told apart by JWT subject or API key when requireAuth verified one, falling
+// back to their IP, so unchecked headers can't buy a fresh allowance. It must
+// run after requireAuth.
+func rateLimiter(perMinute int) fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:        perMinute,
+		Expiration: time.Minute,
+		Next: func(c *fiber.Ctx) bool {
+			return publicPaths[c.Path()]
+		},
+		KeyGenerator: func(c *fiber.Ctx) string {
+			if claims, ok := c.Locals("claims").(*authClaims); ok && claims.Subject != "" {
+				return "sub:" + claims.Subject
+			}
+			if key, ok := c.Locals("apiKey").(string); ok {
+				return "key:" + key
+			}
+			return "ip:" + c.IP()
+		},
+		// The limiter has already set Retry-After by the time this runs
+		LimitReached: func(c *fiber.Ctx) error {
+			return errorResponse(c, 429, "Too many requests", nil)
+		},
+	})
+}
+
+// streamingPaths write their bodies incrementally, so compression, which
+// buffers, would hold back events or the whole stream.
+var streamingPaths = map[string]bool{
+	"/api/customers/stream":     true,
+	"/api/customers/export.csv": true,
+	"/api/orders/live":          true,
+}
+
+// compression compresses responses at level for clients that accept it,
+// except for streamed ones.
+func compression(level compress.Level) fiber.Handler {
+	return compress.New(compress.Config{
+		Level: level,
+		Next: func(c *fiber.Ctx) bool {
+			return streamingPaths[c.Path()] || c.Query("stream") == "true"
+		},
+	})
+}
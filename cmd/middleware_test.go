@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// TestRateLimiterKeys checks that only a verified API key gets a bucket of
+// its own, and that unchecked keys share their IP's.
+func TestRateLimiterKeys(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  fiber.Handler
+		keys  []string
+		limit bool
+	}{
+		{"unchecked keys", nil, []string{"a", "b"}, true},
+		{"rejected keys", requireAuth(apiKeyAuth("secret")), []string{"a", "b"}, false},
+		{"verified key", requireAuth(apiKeyAuth("secret")), []string{"secret", "secret"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			if tt.auth != nil {
+				app.Use(tt.auth)
+			}
+			app.Use(rateLimiter(1))
+			app.Get("/api/customers", func(c *fiber.Ctx) error { return c.SendStatus(200) })
+
+			var statuses []int
+			for _, key := range tt.keys {
+				req := httptest.NewRequest(http.MethodGet, "/api/customers", nil)
+				req.Header.Set("X-API-Key", key)
+				resp, err := app.Test(req, -1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				statuses = append(statuses, resp.StatusCode)
+			}
+			if got := statuses[len(statuses)-1] == fiber.StatusTooManyRequests; got != tt.limit {
+				t.Errorf("statuses = %v, want the last limited: %v", statuses, tt.limit)
+			}
+		})
+	}
+}
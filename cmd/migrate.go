@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+// fieldPath matches dotted field paths made of plain identifiers, such as
+// "phone" or "address.zip", so renames can't name operators or odd keys.
+var fieldPath = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// checkRename rejects a rename of the field from to the field to that
+// MongoDB would refuse or that would break the API.
+func checkRename(from, to string) error {
+	for _, field := range []string{from, to} {
+		if !fieldPath.MatchString(field) {
+			return fmt.Errorf("invalid field name %q", field)
+		}
+		if field == "_id" || strings.HasPrefix(field, "_id.") {
+			return errors.New("_id can't be renamed")
+		}
+	}
+	if from == to {
+		return errors.New("from and to are the same field")
+	}
+	// $rename can't move a field into itself or its parent
+	if strings.HasPrefix(to, from+".") || strings.HasPrefix(from, to+".") {
+		return fmt.Errorf("%q and %q overlap", from, to)
+	}
+	return nil
+}
+
+// renameField renames a field in every document of the collection in the
+// path that has it, given {"from": "old", "to": "new"}. A document that
+// already has the new field has it overwritten. ?dryRun=true only counts the
+// documents that would change. It must be routed behind requireAdmin.
+func (s *Store) renameField(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	coll := s.collection(c.Params("collection"))
+	if coll == nil {
+		return errorResponse(c, 404, "Collection not found", nil)
+	}
+
+	var body struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bodyParseError(c, err)
+	}
+	if err := checkRename(body.From, body.To); err != nil {
+		return errorResponse(c, 400, "Invalid rename", err)
+	}
+
+	filter := bson.M{body.From: bson.M{"$exists": true}}
+
+	// A dry run reports how many documents have the field without renaming
+	if c.Query("dryRun") == "true" {
+		count, err := coll.CountDocuments(ctx, filter)
+		if err != nil {
+			return errorResponse(c, 500, "Error counting "+coll.Name(), err)
+		}
+		return c.JSON(fiber.Map{"dry_run": true, "matched_count": count})
+	}
+
+	result, err := coll.UpdateMany(ctx, filter, bson.M{"$rename": bson.M{body.From: body.To}})
+	if err != nil {
+		return errorResponse(c, 500, "Error renaming field", err)
+	}
+
+	loggerFrom(c).Warn("Renamed field.",
+		zap.String("collection", coll.Name()),
+		zap.String("from", body.From),
+		zap.String("to", body.To),
+		zap.Int64("modified_count", result.ModifiedCount))
+
+	return c.JSON(fiber.Map{
+		"matched_count":  result.MatchedCount,
+		"modified_count": result.ModifiedCount,
+	})
+}
@@ -0,0 +1,846 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+type OrderItem struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	ProductID primitive.ObjectID `json:"product_id" bson:"product_id" validate:"required"`
+	Quantity  int                `json:"quantity" bson:"quantity" validate:"min=1"`
+	Price     float64            `json:"price" bson:"price" validate:"gte=0"`
+}
+
+type Order struct {
+	ID         primitive.ObjectID `json:"_id" bson:"_id,omitempty"`
+	CustomerID primitive.ObjectID `json:"customer_id" bson:"customer_id" validate:"required"`
+	Items      []OrderItem        `json:"items" bson:"items" validate:"min=1,dive"`
+	Status     string             `json:"status" bson:"status"`
+	Total      float64            `json:"total" bson:"total"`
+
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+}
+
+var (
+	errProductNotFound   = errors.New("product not found")
+	errInsufficientStock = errors.New("insufficient stock")
+	errPriceMismatch     = errors.New("price mismatch")
+)
+
+// orderFilter builds the filter for the order list query parameters: the
+// orderPolicy equality filters plus a created_at range from the from
+// and to parameters.
+func orderFilter(c *fiber.Ctx) (bson.M, error) {
+	filter, err := buildFilter(c, orderPolicy.filter)
+	if err != nil {
+		return nil, err
+	}
+	dateRange, err := parseDateRange(c, "created_at")
+	if err != nil {
+		return nil, err
+	}
+	for field, cond := range dateRange {
+		filter[field] = cond
+	}
+	return filter, nil
+}
+
+// expandCustomerStages embeds each order's customer, joined on the order's
+// customer_id against the customer _id, under the customer field. Orders whose
+// customer no longer exists get null.
+func (s *Store) expandCustomerStages() mongo.Pipeline {
+	return mongo.Pipeline{
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.customers.Name(),
+			"localField":   "customer_id",
+			"foreignField": "_id",
+			"as":           "customer",
+		}}},
+		{{Key: "$set", Value: bson.M{
+			"customer": bson.M{"$ifNull": bson.A{bson.M{"$first": "$customer"}, nil}},
+		}}},
+	}
+}
+
+// computeTotalStage sets each order's total to the sum of its items' price
+// times quantity, overriding the stored total.
+var computeTotalStage = bson.D{{Key: "$addFields", Value: bson.M{
+	"total": bson.M{"$sum": bson.M{"$map": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$items", bson.A{}}},
+		"as":    "item",
+		"in":    bson.M{"$multiply": bson.A{"$$item.price", "$$item.quantity"}},
+	}}},
+}}}
+
+// listOrders responds with a page of the orders matching filter, aggregated
+// when ?expand=customer or ?computeTotals=true asks for more than a find does.
+func (s *Store) listOrders(c *fiber.Ctx, filter bson.M) error {
+	if c.Query("expand") == "customer" || c.Query("computeTotals") == "true" {
+		return s.aggregateOrders(c, filter)
+	}
+	return s.listDocuments(c, s.orders, filter, orderPolicy)
+}
+
+// aggregateOrders responds with a page of the orders matching filter run
+// through an aggregation, for the ?expand=customer and ?computeTotals=true
+// options the plain find can't do. Paging, sorting and collation work as in
+// listDocuments.
+func (s *Store) aggregateOrders(c *fiber.Ctx, filter bson.M) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	excludeDeleted(c, filter)
+
+	page, limit := parsePagination(c, s.pageSize(s.orders.Name()))
+	projection, err := parseProjection(c.Query("fields"), orderPolicy.project)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid fields parameter", err)
+	}
+	sort, err := parseSort(c.Query("sort"), orderPolicy.sort)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid sort parameter", err)
+	}
+	collation, err := parseCollation(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid collation parameter", err)
+	}
+
+	countOpts := options.Count()
+	opts := options.Aggregate()
+	if collation != nil {
+		countOpts.SetCollation(collation)
+		opts.SetCollation(collation)
+	}
+	total, err := s.orders.CountDocuments(ctx, filter, countOpts)
+	if err != nil {
+		return errorResponse(c, 500, "Error counting orders", err)
+	}
+
+	// Totals are computed before sorting so ?sort=total uses them, and the
+	// customer lookup runs only for the page returned
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: filter}}}
+	if c.Query("computeTotals") == "true" {
+		pipeline = append(pipeline, computeTotalStage)
+	}
+	if len(sort) > 0 {
+		pipeline = append(pipeline, bson.D{{Key: "$sort", Value: sort}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$skip", Value: (page - 1) * limit}},
+		bson.D{{Key: "$limit", Value: limit}},
+	)
+	if c.Query("expand") == "customer" {
+		pipeline = append(pipeline, s.expandCustomerStages()...)
+	}
+	if projection != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$project", Value: projection}})
+	}
+
+	cursor, err := s.orders.Aggregate(ctx, pipeline, opts)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding orders", err)
+	}
+	defer cursor.Close(ctx)
+
+	orders, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding orders", err)
+	}
+
+	setPaginationHeaders(c, total, page, limit)
+	meta := buildPagination(total, page, limit)
+	meta["count"] = len(orders)
+	return respond(c, normalizeDocs(orders), meta)
+}
+
+func (s *Store) countOrders(c *fiber.Ctx) error {
+	filter, err := orderFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	return countDocuments(c, s.orders, filter)
+}
+
+// orderDistinctFields lists the order fields distinctOrders reports on.
+var orderDistinctFields = map[string]bool{
+	"status":      true,
+	"customer_id": true,
+}
+
+func (s *Store) distinctOrders(c *fiber.Ctx) error {
+	return distinctValues(c, s.orders, orderDistinctFields)
+}
+
+func (s *Store) getOrderByID(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+	var order bson.M
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	excludeDeleted(c, filter)
+
+	if err := s.orders.FindOne(ctx, filter).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errorResponse(c, 404, "Order not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding order", err)
+	}
+
+	return respond(c, normalizeDoc(order), nil)
+}
+
+// getCustomerOrders responds with a page of the orders of the customer in the
+// path, filtered like the order list. A customer with no orders gets an empty
+// page, while one that doesn't exist gets a 404.
+func (s *Store) getCustomerOrders(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	customerFilter, idErr := customerIDFilter(c.Params("id"))
+	excludeDeleted(c, customerFilter)
+
+	// Decoded loosely since legacy customers have string IDs
+	var customer struct {
+		ID interface{} `bson:"_id"`
+	}
+	opts := options.FindOne().SetProjection(bson.M{"_id": 1})
+	if err := s.customers.FindOne(ctx, customerFilter, opts).Decode(&customer); err != nil {
+		if err == mongo.ErrNoDocuments {
+			if idErr != nil {
+				return errorResponse(c, 400, "Invalid ID format", idErr)
+			}
+			return errorResponse(c, 404, "Customer not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding customer", err)
+	}
+
+	filter, err := orderFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	filter["customer_id"] = customer.ID
+
+	return s.listDocuments(c, s.orders, filter, orderPolicy)
+}
+
+func (s *Store) deleteOrder(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+
+	result, err := s.orders.DeleteOne(ctx, filter)
+	if err != nil {
+		return errorResponse(c, 500, "Error deleting order", err)
+	}
+	if result.DeletedCount == 0 {
+		return errorResponse(c, 404, "Order not found", nil)
+	}
+
+	return c.SendStatus(204)
+}
+
+// deleteOrdersByFilter deletes every order matching the filter query
+// parameters. An empty filter is refused so a bare request can't wipe the
+// collection, and ?dryRun=true only counts the matches.
+func (s *Store) deleteOrdersByFilter(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	filter, err := orderFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	if len(filter) == 0 {
+		return errorResponse(c, 400, "A filter is required to delete orders", nil)
+	}
+
+	// A dry run reports how many orders the filter matches without deleting
+	if c.Query("dryRun") == "true" {
+		count, err := s.orders.CountDocuments(ctx, filter)
+		if err != nil {
+			return errorResponse(c, 500, "Error counting orders", err)
+		}
+		loggerFrom(c).Info("Dry run of deleting orders by filter.",
+			zap.Any("filter", filter),
+			zap.Int64("matched_count", count))
+		return c.JSON(fiber.Map{"dry_run": true, "matched_count": count})
+	}
+
+	result, err := s.orders.DeleteMany(ctx, filter)
+	if err != nil {
+		return errorResponse(c, 500, "Error deleting orders", err)
+	}
+
+	loggerFrom(c).Warn("Deleted orders by filter.",
+		zap.Any("filter", filter),
+		zap.Int64("deleted_count", result.DeletedCount))
+
+	return c.JSON(fiber.Map{"deleted_count": result.DeletedCount})
+}
+
+// createOrder inserts an order and decrements the stock of every product it
+// references in a single transaction, so concurrent orders can't oversell.
+// Every product must exist, not be soft-deleted and still cost the price its
+// item was added at.
+func (s *Store) createOrder(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	var order Order
+	if err := c.BodyParser(&order); err != nil {
+		return bodyParseError(c, err)
+	}
+	if errs := validateStruct(order); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	order.Total = 0
+	for i, item := range order.Items {
+		// Items get their own IDs so they can be updated individually
+		order.Items[i].ID = primitive.NewObjectID()
+		order.Total += item.Price * float64(item.Quantity)
+	}
+
+	// Let MongoDB generate the ID, and never trust a client-sent timestamp
+	order.ID = primitive.NilObjectID
+	order.Status = "pending"
+	order.CreatedAt = time.Now().UTC()
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return errorResponse(c, 500, "Error creating order", err)
+	}
+	defer session.EndSession(ctx)
+
+	insertedID, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		for _, item := range order.Items {
+			// The same checks validateOrder reports on, so an order it passes
+			// is created
+			live := bson.M{"_id": item.ProductID, "deleted_at": bson.M{"$exists": false}}
+			var product bson.M
+			opts := options.FindOne().SetProjection(bson.M{"price": 1})
+			if err := s.products.FindOne(sc, live, opts).Decode(&product); err != nil {
+				if err == mongo.ErrNoDocuments {
+					return nil, fmt.Errorf("%w: %s", errProductNotFound, item.ProductID.Hex())
+				}
+				return nil, err
+			}
+			if price, ok := numberValue(product["price"]); !ok || !samePrice(price, item.Price) {
+				return nil, fmt.Errorf("%w: %s costs %v, not %v", errPriceMismatch, item.ProductID.Hex(), product["price"], item.Price)
+			}
+
+			// Only matches when there is enough stock left, so the decrement
+			// can never take stock below zero
+			live["stock"] = bson.M{"$gte": item.Quantity}
+			update := bson.M{"$inc": bson.M{"stock": -item.Quantity}}
+			result, err := s.products.UpdateOne(sc, live, update)
+			if err != nil {
+				return nil, err
+			}
+			if result.MatchedCount == 0 {
+				return nil, fmt.Errorf("%w: %s", errInsufficientStock, item.ProductID.Hex())
+			}
+		}
+
+		result, err := s.orders.InsertOne(sc, order)
+		if err != nil {
+			return nil, err
+		}
+		return result.InsertedID, nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errProductNotFound):
+			return errorResponse(c, 400, "Order references an unknown product", err)
+		case errors.Is(err, errInsufficientStock):
+			return errorResponse(c, 409, "Insufficient stock", err)
+		case errors.Is(err, errPriceMismatch):
+			return errorResponse(c, 409, "Product price has changed", err)
+		}
+		return errorResponse(c, 500, "Error creating order", err)
+	}
+
+	// ObjectID marshals to its hex string in JSON
+	order.ID = insertedID.(primitive.ObjectID)
+
+	return c.Status(201).JSON(order)
+}
+
+// orderTransitions maps each order status to the statuses an order in it may
+// move to. Delivered and cancelled orders are final.
+var orderTransitions = map[string][]string{
+	"pending":   {"paid", "cancelled"},
+	"paid":      {"shipped", "cancelled"},
+	"shipped":   {"delivered"},
+	"delivered": {},
+	"cancelled": {},
+}
+
+// updateOrderStatus moves an order to the status in the request body if the
+// lifecycle allows it from the order's current status. The check and update
+// happen in one query so concurrent transitions can't both apply.
+func (s *Store) updateOrderStatus(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bodyParseError(c, err)
+	}
+	if _, ok := orderTransitions[body.Status]; !ok {
+		return errorResponse(c, 400, "Unknown order status", fmt.Errorf("unknown status %q", body.Status))
+	}
+
+	// Only match orders in a status that may move to the requested one
+	from := bson.A{}
+	for status, next := range orderTransitions {
+		for _, to := range next {
+			if to == body.Status {
+				from = append(from, status)
+			}
+		}
+	}
+
+	filter := bson.M{"_id": objectID, "status": bson.M{"$in": from}}
+	excludeDeleted(c, filter)
+	update := bson.M{"$set": bson.M{
+		"status":            body.Status,
+		"status_updated_at": time.Now().UTC(),
+	}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order bson.M
+	err = s.orders.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order)
+	if err == nil {
+		return c.JSON(normalizeDoc(order))
+	}
+	if err != mongo.ErrNoDocuments {
+		return errorResponse(c, 500, "Error updating order status", err)
+	}
+
+	// Nothing matched, either because the order doesn't exist or because its
+	// status doesn't allow the transition
+	var current struct {
+		Status string `bson:"status"`
+	}
+	filter = bson.M{"_id": objectID}
+	excludeDeleted(c, filter)
+	if err := s.orders.FindOne(ctx, filter).Decode(&current); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errorResponse(c, 404, "Order not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding order", err)
+	}
+	return errorResponse(c, 409, "Illegal status transition",
+		fmt.Errorf("cannot move an order from %s to %s", current.Status, body.Status))
+}
+
+// errOrderItemNotFound is returned when an order has no item with the
+// requested ID.
+var errOrderItemNotFound = errors.New("order item not found")
+
+// updateOrderItem sets the quantity and/or price of a single order item,
+// matched by its ID with an array filter. A quantity change reserves or
+// releases the difference in the product's stock, and the order total is
+// recomputed, all in one transaction.
+func (s *Store) updateOrderItem(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	// Use the ObjectID from the provided ID string
+	orderID, err := parseObjectID(c.Params("id"))
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+	itemID, err := parseObjectID(c.Params("itemId"))
+	if err != nil {
+		return errorResponse(c, 400, "Invalid item ID format", err)
+	}
+
+	var body struct {
+		Quantity *int     `json:"quantity" validate:"omitempty,min=1"`
+		Price    *float64 `json:"price" validate:"omitempty,gte=0"`
+	}
+	if err := c.BodyParser(&body); err != nil {
+		return bodyParseError(c, err)
+	}
+	if body.Quantity == nil && body.Price == nil {
+		return errorResponse(c, 400, "No fields to update", nil)
+	}
+	if errs := validateStruct(body); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	set := bson.M{}
+	if body.Quantity != nil {
+		set["items.$[item].quantity"] = *body.Quantity
+	}
+	if body.Price != nil {
+		set["items.$[item].price"] = *body.Price
+	}
+
+	session, err := s.client.StartSession()
+	if err != nil {
+		return errorResponse(c, 500, "Error updating order item", err)
+	}
+	defer session.EndSession(ctx)
+
+	filter := bson.M{"_id": orderID, "items._id": itemID}
+	excludeDeleted(c, filter)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		var order Order
+		if err := s.orders.FindOne(sc, filter).Decode(&order); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return nil, errOrderItemNotFound
+			}
+			return nil, err
+		}
+
+		if body.Quantity != nil {
+			for _, item := range order.Items {
+				if item.ID != itemID {
+					continue
+				}
+				if err := s.adjustStock(sc, item.ProductID, *body.Quantity-item.Quantity); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		opts := options.Update().SetArrayFilters(options.ArrayFilters{
+			Filters: []interface{}{bson.M{"item._id": itemID}},
+		})
+		if _, err := s.orders.UpdateOne(sc, filter, bson.M{"$set": set}, opts); err != nil {
+			return nil, err
+		}
+		_, err := s.orders.UpdateOne(sc, bson.M{"_id": orderID}, mongo.Pipeline{computeTotalStage})
+		return nil, err
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, errOrderItemNotFound):
+			return errorResponse(c, 404, "Order item not found", nil)
+		case errors.Is(err, errInsufficientStock):
+			return errorResponse(c, 409, "Insufficient stock", err)
+		}
+		return errorResponse(c, 500, "Error updating order item", err)
+	}
+
+	var order bson.M
+	if err := s.orders.FindOne(ctx, bson.M{"_id": orderID}).Decode(&order); err != nil {
+		return errorResponse(c, 500, "Error finding order", err)
+	}
+
+	return c.JSON(normalizeDoc(order))
+}
+
+// adjustStock takes delta more units of a product out of stock, or puts them
+// back when delta is negative, failing with errInsufficientStock rather than
+// taking stock below zero.
+func (s *Store) adjustStock(ctx context.Context, productID primitive.ObjectID, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+
+	filter := bson.M{"_id": productID}
+	if delta > 0 {
+		filter["stock"] = bson.M{"$gte": delta}
+	}
+	result, err := s.products.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": -delta}})
+	if err != nil {
+		return err
+	}
+	// Returning stock to a product that has since been deleted is a no-op
+	if result.MatchedCount == 0 && delta > 0 {
+		return fmt.Errorf("%w: %s", errInsufficientStock, productID.Hex())
+	}
+	return nil
+}
+
+// getOrderItems responds with just the line items of an order.
+func (s *Store) getOrderItems(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	excludeDeleted(c, filter)
+	opts := options.FindOne().SetProjection(bson.M{"_id": 0, "items": 1})
+
+	var order struct {
+		Items []bson.M `bson:"items"`
+	}
+	if err := s.orders.FindOne(ctx, filter, opts).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errorResponse(c, 404, "Order not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding order", err)
+	}
+
+	if order.Items == nil {
+		order.Items = []bson.M{}
+	}
+
+	return respond(c, normalizeDocs(order.Items), fiber.Map{"count": len(order.Items)})
+}
+
+// orderProduct is a product an order references, with the quantity ordered
+// across all of the order's items for it. Product is nil when the product has
+// since been deleted.
+type orderProduct struct {
+	ProductID primitive.ObjectID `json:"product_id"`
+	Quantity  int                `json:"quantity"`
+	Product   bson.M             `json:"product"`
+}
+
+// getOrderProducts responds with the products the order in the path
+// references, fetched in one query, in the order they first appear in its
+// items.
+func (s *Store) getOrderProducts(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	excludeDeleted(c, filter)
+	opts := options.FindOne().SetProjection(bson.M{"_id": 0, "items": 1})
+
+	var order struct {
+		Items []OrderItem `bson:"items"`
+	}
+	if err := s.orders.FindOne(ctx, filter, opts).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errorResponse(c, 404, "Order not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding order", err)
+	}
+
+	// Items for the same product are merged into one entry
+	entries := []*orderProduct{}
+	byID := map[primitive.ObjectID]*orderProduct{}
+	productIDs := bson.A{}
+	for _, item := range order.Items {
+		if entry, ok := byID[item.ProductID]; ok {
+			entry.Quantity += item.Quantity
+			continue
+		}
+		entry := &orderProduct{ProductID: item.ProductID, Quantity: item.Quantity}
+		byID[item.ProductID] = entry
+		entries = append(entries, entry)
+		productIDs = append(productIDs, item.ProductID)
+	}
+
+	if len(productIDs) > 0 {
+		productFilter := bson.M{"_id": bson.M{"$in": productIDs}}
+		excludeDeleted(c, productFilter)
+
+		cursor, err := s.products.Find(ctx, productFilter)
+		if err != nil {
+			return errorResponse(c, 500, "Error finding products", err)
+		}
+		defer cursor.Close(ctx)
+
+		products, err := decodeAll(ctx, c, cursor)
+		if err != nil {
+			return errorResponse(c, 500, "Error finding products", err)
+		}
+		for _, product := range products {
+			if id, ok := product["_id"].(primitive.ObjectID); ok && byID[id] != nil {
+				byID[id].Product = normalizeDoc(product)
+			}
+		}
+	}
+
+	missingIDs := []string{}
+	for _, entry := range entries {
+		if entry.Product == nil {
+			missingIDs = append(missingIDs, entry.ProductID.Hex())
+		}
+	}
+
+	return respond(c, entries, fiber.Map{
+		"count":       len(entries),
+		"missing_ids": missingIDs,
+	})
+}
+
+// orderIssue is a problem validateOrder found with an order item.
+type orderIssue struct {
+	Index     int    `json:"index"`
+	ProductID string `json:"product_id"`
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+}
+
+// numberValue returns a numeric BSON value as a float64.
+func numberValue(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case primitive.Decimal128:
+		f, err := strconv.ParseFloat(v.String(), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// samePrice reports whether two prices are equal, allowing for the rounding
+// of float64 arithmetic.
+func samePrice(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9
+}
+
+// validateOrder checks the order in the request body the way createOrder
+// would, without writing anything: that every product exists, has enough
+// stock for the order's items combined and still costs the price the item
+// was added at. It responds with the issues found and the order's total at
+// the current prices.
+func (s *Store) validateOrder(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	var order Order
+	if err := c.BodyParser(&order); err != nil {
+		return bodyParseError(c, err)
+	}
+	if errs := validateStruct(order); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	productIDs := bson.A{}
+	ordered := map[primitive.ObjectID]int{}
+	for _, item := range order.Items {
+		if _, ok := ordered[item.ProductID]; !ok {
+			productIDs = append(productIDs, item.ProductID)
+		}
+		ordered[item.ProductID] += item.Quantity
+	}
+
+	// Soft-deleted products can't be ordered, whatever ?includeDeleted says
+	filter := bson.M{"_id": bson.M{"$in": productIDs}, "deleted_at": bson.M{"$exists": false}}
+	opts := options.Find().SetProjection(bson.M{"price": 1, "stock": 1})
+
+	cursor, err := s.products.Find(ctx, filter, opts)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding products", err)
+	}
+	defer cursor.Close(ctx)
+
+	products, err := decodeAll(ctx, c, cursor)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding products", err)
+	}
+	byID := make(map[primitive.ObjectID]bson.M, len(products))
+	for _, product := range products {
+		if id, ok := product["_id"].(primitive.ObjectID); ok {
+			byID[id] = product
+		}
+	}
+
+	issues := []orderIssue{}
+	stockChecked := map[primitive.ObjectID]bool{}
+	var submittedTotal, total float64
+	for i, item := range order.Items {
+		submittedTotal += item.Price * float64(item.Quantity)
+		issue := func(code, format string, args ...interface{}) {
+			issues = append(issues, orderIssue{
+				Index:     i,
+				ProductID: item.ProductID.Hex(),
+				Code:      code,
+				Message:   fmt.Sprintf(format, args...),
+			})
+		}
+
+		product, ok := byID[item.ProductID]
+		if !ok {
+			issue("product_not_found", "product does not exist")
+			continue
+		}
+
+		price, ok := numberValue(product["price"])
+		if !ok {
+			issue("price_unavailable", "product has no price")
+			continue
+		}
+		total += price * float64(item.Quantity)
+		if !samePrice(price, item.Price) {
+			issue("price_mismatch", "price is %v, not %v", price, item.Price)
+		}
+
+		// Stock is checked once against every item for the product combined,
+		// as createOrder decrements it
+		if stockChecked[item.ProductID] {
+			continue
+		}
+		stockChecked[item.ProductID] = true
+		stock, _ := numberValue(product["stock"])
+		if float64(ordered[item.ProductID]) > stock {
+			issue("insufficient_stock", "%d ordered but only %v in stock", ordered[item.ProductID], stock)
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"valid":           len(issues) == 0,
+		"issues":          issues,
+		"total":           total,
+		"submitted_total": submittedTotal,
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import "strings"
+
+// fieldPolicy is the whitelist of a collection's fields that clients may name
+// in query parameters. Anything else is rejected with a 400, so fields the
+// API doesn't mean to expose can't be selected or probed by name.
+type fieldPolicy struct {
+	// filter maps the fields clients may filter by to the kind of value the
+	// query parameter is coerced to
+	filter map[string]string
+	// sort lists the fields clients may sort by
+	sort map[string]bool
+	// project lists the fields clients may select with ?fields= or test with
+	// the exists and type schema filters, subfields included
+	project map[string]bool
+}
+
+// allows reports whether field, or the top-level field it is a subfield of,
+// is in fields.
+func allows(fields map[string]bool, field string) bool {
+	top, _, _ := strings.Cut(field, ".")
+	return fields[top]
+}
+
+// The field policies of the API collections. Exposing a new field to clients
+// means adding it here.
+var (
+	customerPolicy = fieldPolicy{
+		filter: map[string]string{
+			"name":  filterString,
+			"email": filterString,
+			"phone": filterString,
+		},
+		sort: map[string]bool{
+			"name":       true,
+			"email":      true,
+			"created_at": true,
+		},
+		project: map[string]bool{
+			"_id":        true,
+			"name":       true,
+			"email":      true,
+			"phone":      true,
+			"version":    true,
+			"created_at": true,
+			"updated_at": true,
+			"deleted_at": true,
+		},
+	}
+
+	productPolicy = fieldPolicy{
+		filter: map[string]string{
+			"category": filterString,
+		},
+		sort: map[string]bool{
+			"name":     true,
+			"price":    true,
+			"category": true,
+			"stock":    true,
+		},
+		project: map[string]bool{
+			"_id":         true,
+			"sku":         true,
+			"name":        true,
+			"description": true,
+			"category":    true,
+			"price":       true,
+			"stock":       true,
+			"created_at":  true,
+			"updated_at":  true,
+			"deleted_at":  true,
+		},
+	}
+
+	orderPolicy = fieldPolicy{
+		filter: map[string]string{
+			"status":      filterString,
+			"customer_id": filterObjectID,
+			"total":       filterNumber,
+		},
+		sort: map[string]bool{
+			"status":     true,
+			"total":      true,
+			"created_at": true,
+		},
+		project: map[string]bool{
+			"_id":               true,
+			"customer_id":       true,
+			"items":             true,
+			"status":            true,
+			"total":             true,
+			"created_at":        true,
+			"status_updated_at": true,
+			"deleted_at":        true,
+			// Embedded by ?expand=customer
+			"customer": true,
+		},
+	}
+)
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// productFilter builds the filter for the product list query parameters: the
+// productPolicy equality filters plus a price range from the minPrice
+// and maxPrice parameters.
+func productFilter(c *fiber.Ctx) (bson.M, error) {
+	filter, err := buildFilter(c, productPolicy.filter)
+	if err != nil {
+		return nil, err
+	}
+	priceRange, err := parseNumberRange(c, "price", "minPrice", "maxPrice")
+	if err != nil {
+		return nil, err
+	}
+	for field, cond := range priceRange {
+		filter[field] = cond
+	}
+	return filter, nil
+}
+
+// listProducts responds with a page of the products matching filter, or
+// given ?stream=true, all of them streamed.
+func (s *Store) listProducts(c *fiber.Ctx, filter bson.M) error {
+	if c.Query("stream") == "true" {
+		return s.streamProducts(c, filter)
+	}
+	return s.listDocuments(c, s.products, filter, productPolicy)
+}
+
+// streamProducts writes all the matching products as a JSON array one document at
+// a time, for exports too large to buffer. It has no envelope since the count
+// isn't known up front. The status is already sent by the time the cursor is
+// read, so a failure part way through is logged and cuts the array short,
+// leaving the client with invalid JSON rather than a silently partial export.
+func (s *Store) streamProducts(c *fiber.Ctx, filter bson.M) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	excludeDeleted(c, filter)
+
+	projection, err := parseProjection(c.Query("fields"), productPolicy.project)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid fields parameter", err)
+	}
+	sort, err := parseSort(c.Query("sort"), productPolicy.sort)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid sort parameter", err)
+	}
+
+	opts := options.Find()
+	if len(sort) > 0 {
+		opts.SetSort(sort)
+	}
+	if projection != nil {
+		opts.SetProjection(projection)
+	}
+
+	// The find runs under the request deadline so errors can still get a
+	// proper response; the cursor outlives the handler and is only read
+	// once Fiber starts writing the body
+	cursor, err := s.products.Find(ctx, filter, opts)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding products", err)
+	}
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	streamBody(c, func(w *bodyStream) {
+		streamCtx := context.Background()
+		defer cursor.Close(streamCtx)
+
+		w.WriteString("[")
+		for n := 0; cursor.Next(streamCtx); n++ {
+			var product bson.M
+			if err := cursor.Decode(&product); err != nil {
+				w.logger.Error("Failed to decode streamed product.", zap.Error(err))
+				return
+			}
+			doc, err := json.Marshal(normalizeDoc(product))
+			if err != nil {
+				w.logger.Error("Failed to encode streamed product.", zap.Error(err))
+				return
+			}
+
+			if n > 0 {
+				w.WriteString(",")
+			}
+			w.Write(doc)
+			if !w.wrote() {
+				return
+			}
+		}
+		if err := cursor.Err(); err != nil {
+			w.logger.Error("Failed to stream products.", zap.Error(err))
+			return
+		}
+		w.WriteString("]")
+		w.flush()
+	})
+
+	return nil
+}
+
+func (s *Store) countProducts(c *fiber.Ctx) error {
+	filter, err := productFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	return countDocuments(c, s.products, filter)
+}
+
+// productStats responds with the minimum, maximum and average price of the
+// products matching the list filters, such as ?category=, and how many there
+// are. With no matching products the prices are null.
+func (s *Store) productStats(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	filter, err := productFilter(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid filter", err)
+	}
+	excludeDeleted(c, filter)
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{
+			"_id":       nil,
+			"min_price": bson.M{"$min": "$price"},
+			"max_price": bson.M{"$max": "$price"},
+			"avg_price": bson.M{"$avg": "$price"},
+			"count":     bson.M{"$sum": 1},
+		}}},
+		{{Key: "$project", Value: bson.M{"_id": 0}}},
+	}
+
+	cursor, err := s.products.Aggregate(ctx, pipeline)
+	if err != nil {
+		return errorResponse(c, 500, "Error computing product stats", err)
+	}
+	defer cursor.Close(ctx)
+
+	results := []bson.M{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return errorResponse(c, 500, "Error computing product stats", err)
+	}
+
+	// $group outputs nothing at all when no products match
+	if len(results) == 0 {
+		return c.JSON(fiber.Map{"min_price": nil, "max_price": nil, "avg_price": nil, "count": 0})
+	}
+	return c.JSON(normalizeDoc(results[0]))
+}
+
+// productDistinctFields lists the product fields distinctProducts reports on.
+var productDistinctFields = map[string]bool{
+	"category": true,
+}
+
+func (s *Store) distinctProducts(c *fiber.Ctx) error {
+	return distinctValues(c, s.products, productDistinctFields)
+}
+
+func (s *Store) getProductByID(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+	var product bson.M
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	excludeDeleted(c, filter)
+
+	if err := s.products.FindOne(ctx, filter).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return errorResponse(c, 404, "Product not found", nil)
+		}
+		return errorResponse(c, 500, "Error finding product", err)
+	}
+
+	return respond(c, normalizeDoc(product), nil)
+}
+
+// getProductsByIDs responds with the products whose IDs are listed in the
+// request body, fetched in a single query. IDs that aren't valid ObjectIDs
+// and IDs with no matching product are reported in the meta instead of
+// failing the batch.
+func (s *Store) getProductsByIDs(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	var ids []string
+	if err := c.BodyParser(&ids); err != nil {
+		return bodyParseError(c, err)
+	}
+	if len(ids) == 0 {
+		return errorResponse(c, 400, "No product IDs given", nil)
+	}
+	if len(ids) > maxLimit {
+		return errorResponse(c, 400, fmt.Sprintf("At most %d product IDs can be fetched at once", maxLimit), nil)
+	}
+
+	objectIDs := bson.A{}
+	invalidIDs := []string{}
+	for _, id := range ids {
+		objectID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			invalidIDs = append(invalidIDs, id)
+			continue
+		}
+		objectIDs = append(objectIDs, objectID)
+	}
+
+	products := []bson.M{}
+	if len(objectIDs) > 0 {
+		filter := bson.M{"_id": bson.M{"$in": objectIDs}}
+		excludeDeleted(c, filter)
+
+		cursor, err := s.products.Find(ctx, filter)
+		if err != nil {
+			return errorResponse(c, 500, "Error finding products", err)
+		}
+		defer cursor.Close(ctx)
+
+		if products, err = decodeAll(ctx, c, cursor); err != nil {
+			return errorResponse(c, 500, "Error finding products", err)
+		}
+	}
+
+	found := make(map[primitive.ObjectID]bool, len(products))
+	for _, product := range products {
+		if id, ok := product["_id"].(primitive.ObjectID); ok {
+			found[id] = true
+		}
+	}
+	missingIDs := []string{}
+	for _, id := range objectIDs {
+		if objectID := id.(primitive.ObjectID); !found[objectID] {
+			missingIDs = append(missingIDs, objectID.Hex())
+		}
+	}
+
+	return respond(c, normalizeDocs(products), fiber.Map{
+		"count":       len(products),
+		"invalid_ids": invalidIDs,
+		"missing_ids": missingIDs,
+	})
+}
+
+func (s *Store) updateProduct(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	id := c.Params("id")
+
+	// Use the ObjectID from the provided ID string
+	objectID, err := parseObjectID(id)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid ID format", err)
+	}
+
+	var fields bson.M
+	if err := c.BodyParser(&fields); err != nil {
+		return bodyParseError(c, err)
+	}
+	if err := checkDocument(fields); err != nil {
+		return errorResponse(c, 400, "Invalid request body", err)
+	}
+
+	// The ID and creation time are immutable, and deleted_at is only set by
+	// deleting, so never try to set them
+	delete(fields, "_id")
+	delete(fields, "created_at")
+	delete(fields, "updated_at")
+	delete(fields, "deleted_at")
+	if len(fields) == 0 {
+		return errorResponse(c, 400, "No fields to update", nil)
+	}
+	if errs := validateProduct("", fields, true); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+	fields["updated_at"] = time.Now().UTC()
+
+	filter := bson.M{"_id": objectID}
+
+	// Only the provided fields are set, everything else is left untouched
+	result, err := s.products.UpdateOne(ctx, filter, bson.M{"$set": fields})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyResponse(c, "Product", err)
+		}
+		return errorResponse(c, 500, "Error updating product", err)
+	}
+	if result.MatchedCount == 0 {
+		return errorResponse(c, 404, "Product not found", nil)
+	}
+
+	var product bson.M
+	if err := s.products.FindOne(ctx, filter).Decode(&product); err != nil {
+		return errorResponse(c, 500, "Error finding product", err)
+	}
+
+	return c.JSON(normalizeDoc(product))
+}
+
+// upsertProductBySKU creates or updates the product with the SKU in the path,
+// so the supplier feed sync can safely be re-run. The body must be a whole
+// valid product; fields it leaves out are kept on an existing product.
+func (s *Store) upsertProductBySKU(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	sku := c.Params("sku")
+
+	var fields bson.M
+	if err := c.BodyParser(&fields); err != nil {
+		return bodyParseError(c, err)
+	}
+	if err := checkDocument(fields); err != nil {
+		return errorResponse(c, 400, "Invalid request body", err)
+	}
+
+	// The SKU comes from the path, and the ID and timestamps are server-managed
+	delete(fields, "_id")
+	delete(fields, "sku")
+	delete(fields, "created_at")
+	delete(fields, "updated_at")
+	delete(fields, "deleted_at")
+	if errs := validateProduct("", fields, false); len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	now := time.Now().UTC()
+	fields["updated_at"] = now
+
+	filter := bson.M{"sku": sku}
+	update := bson.M{
+		"$set":         fields,
+		"$setOnInsert": bson.M{"created_at": now},
+	}
+	result, err := s.products.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return duplicateKeyResponse(c, "Product", err)
+		}
+		return errorResponse(c, 500, "Error upserting product", err)
+	}
+
+	var product bson.M
+	if err := s.products.FindOne(ctx, filter).Decode(&product); err != nil {
+		return errorResponse(c, 500, "Error finding product", err)
+	}
+
+	created := result.UpsertedID != nil
+	status := 200
+	if created {
+		status = 201
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"created": created,
+		"product": normalizeDoc(product),
+	})
+}
+
+func (s *Store) bulkCreateProducts(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	var products []bson.M
+	if err := c.BodyParser(&products); err != nil {
+		return bodyParseError(c, err)
+	}
+	if len(products) == 0 {
+		return errorResponse(c, 400, "No products to insert", nil)
+	}
+
+	// Any invalid product rejects the whole batch, before anything is written
+	var errs []fieldError
+	for i, product := range products {
+		if err := checkDocument(product); err != nil {
+			return errorResponse(c, 400, fmt.Sprintf("Invalid product at index %d", i), err)
+		}
+		errs = append(errs, validateProduct(fmt.Sprintf("[%d].", i), product, false)...)
+	}
+	if len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	now := time.Now().UTC()
+	docs := make([]interface{}, len(products))
+	for i, product := range products {
+		delete(product, "_id")
+		delete(product, "updated_at")
+		delete(product, "deleted_at")
+		product["created_at"] = now
+		docs[i] = product
+	}
+
+	// Unordered inserts keep going past a bad document instead of aborting
+	ordered := c.Query("ordered") != "false"
+	opts := options.InsertMany().SetOrdered(ordered)
+
+	result, err := s.products.InsertMany(ctx, docs, opts)
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return errorResponse(c, 500, "Error creating products", err)
+	}
+
+	// The driver reports an ID for every document it was given, so drop the
+	// ones that failed and, for ordered inserts, everything after the first failure
+	failed := make(map[int]bool)
+	writeErrors := []fiber.Map{}
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = true
+		writeErrors = append(writeErrors, fiber.Map{"index": we.Index, "code": we.Code, "message": we.Message})
+	}
+
+	insertedIDs := []interface{}{}
+	for i, id := range result.InsertedIDs {
+		if failed[i] {
+			if ordered {
+				break
+			}
+			continue
+		}
+		insertedIDs = append(insertedIDs, id)
+	}
+
+	status := 201
+	if len(writeErrors) > 0 {
+		status = 207
+		loggerFrom(c).Warn("Some products failed to insert.",
+			zap.Int("inserted", len(insertedIDs)),
+			zap.Int("failed", len(writeErrors)),
+			zap.Error(bulkErr))
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"inserted_ids": insertedIDs,
+		"count":        len(insertedIDs),
+		"errors":       writeErrors,
+	})
+}
+
+// maxBulkUpdates caps the operations a single bulk update may carry.
+const maxBulkUpdates = 1000
+
+// bulkUpdateOperators are the update operators bulk updates may use.
+var bulkUpdateOperators = map[string]bool{
+	"$set":   true,
+	"$unset": true,
+	"$inc":   true,
+	"$mul":   true,
+	"$min":   true,
+	"$max":   true,
+}
+
+// bulkUpdate is one operation of a bulk update: the update to apply to the
+// product matching filter, or to every product matching it when many is set.
+type bulkUpdate struct {
+	Filter bson.M `bson:"filter"`
+	Update bson.M `bson:"update"`
+	Many   bool   `bson:"many"`
+}
+
+// checkBulkUpdate checks the operation at index i of a bulk update, returning
+// field errors for $set values that break the product rules.
+func checkBulkUpdate(i int, op bulkUpdate) ([]fieldError, error) {
+	// An empty filter would update the entire catalog
+	if len(op.Filter) == 0 {
+		return nil, errors.New("filter is required")
+	}
+	if err := checkQuery(op.Filter); err != nil {
+		return nil, err
+	}
+	if len(op.Update) == 0 {
+		return nil, errors.New("update is required")
+	}
+
+	var errs []fieldError
+	for operator, value := range op.Update {
+		if !bulkUpdateOperators[operator] {
+			return nil, fmt.Errorf("update operator %q is not allowed", operator)
+		}
+		fields, ok := value.(bson.M)
+		if !ok || len(fields) == 0 {
+			return nil, fmt.Errorf("%s must be a document of fields", operator)
+		}
+		for field := range fields {
+			switch {
+			case strings.HasPrefix(field, "$"):
+				return nil, fmt.Errorf("%w: field %q", errOperatorInjection, field)
+			case field == "_id" || field == "created_at" || field == "updated_at" || field == "deleted_at":
+				return nil, fmt.Errorf("%s can't be updated", field)
+			}
+		}
+		if operator == "$set" {
+			errs = append(errs, validateProduct(fmt.Sprintf("[%d].update.$set.", i), fields, true)...)
+		}
+	}
+	return errs, nil
+}
+
+// bulkUpdateProducts applies the array of update operations in the request
+// body in one BulkWrite, responding with the outcome of each. The body is
+// MongoDB extended JSON, so filters can match {"$oid": "..."} IDs. Operations
+// run in order and stop at the first failure unless ?ordered=false, and
+// ?dryRun=true only counts the products each operation would match.
+func (s *Store) bulkUpdateProducts(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	// Extended JSON can only be decoded from a document, so wrap the array
+	raw := append(append([]byte(`{"operations":`), c.Body()...), '}')
+	var body struct {
+		Operations []bulkUpdate `bson:"operations"`
+	}
+	if err := bson.UnmarshalExtJSON(raw, false, &body); err != nil {
+		return errorResponse(c, 400, "Invalid request body", err)
+	}
+	ops := body.Operations
+	if len(ops) == 0 {
+		return errorResponse(c, 400, "No operations to apply", nil)
+	}
+	if len(ops) > maxBulkUpdates {
+		return errorResponse(c, 400, fmt.Sprintf("At most %d operations can be applied at once", maxBulkUpdates), nil)
+	}
+
+	// Any invalid operation rejects the whole batch, before anything is written
+	var errs []fieldError
+	for i, op := range ops {
+		opErrs, err := checkBulkUpdate(i, op)
+		if err != nil {
+			return errorResponse(c, 400, fmt.Sprintf("Invalid operation at index %d", i), err)
+		}
+		errs = append(errs, opErrs...)
+	}
+	if len(errs) > 0 {
+		return validationResponse(c, errs)
+	}
+
+	for _, op := range ops {
+		excludeDeleted(c, op.Filter)
+	}
+
+	// A dry run reports how many products each operation matches without
+	// updating. Updates only ever match one product unless many is set.
+	if c.Query("dryRun") == "true" {
+		results := make([]fiber.Map, len(ops))
+		for i, op := range ops {
+			opts := options.Count()
+			if !op.Many {
+				opts.SetLimit(1)
+			}
+			count, err := s.products.CountDocuments(ctx, op.Filter, opts)
+			if err != nil {
+				return errorResponse(c, 500, "Error counting products", err)
+			}
+			results[i] = fiber.Map{"index": i, "matched_count": count}
+		}
+		return c.JSON(fiber.Map{"dry_run": true, "results": results})
+	}
+
+	now := time.Now().UTC()
+	models := make([]mongo.WriteModel, len(ops))
+	for i, op := range ops {
+		set, _ := op.Update["$set"].(bson.M)
+		if set == nil {
+			set = bson.M{}
+			op.Update["$set"] = set
+		}
+		set["updated_at"] = now
+
+		if op.Many {
+			models[i] = mongo.NewUpdateManyModel().SetFilter(op.Filter).SetUpdate(op.Update)
+		} else {
+			models[i] = mongo.NewUpdateOneModel().SetFilter(op.Filter).SetUpdate(op.Update)
+		}
+	}
+
+	ordered := c.Query("ordered") != "false"
+	opts := options.BulkWrite().SetOrdered(ordered)
+
+	result, err := s.products.BulkWrite(ctx, models, opts)
+	var bulkErr mongo.BulkWriteException
+	if err != nil && !errors.As(err, &bulkErr) {
+		return errorResponse(c, 500, "Error updating products", err)
+	}
+
+	// BulkWrite only counts matches across the batch, so each operation is
+	// reported as applied, failed or, after an ordered failure, skipped
+	failed := make(map[int]mongo.BulkWriteError, len(bulkErr.WriteErrors))
+	firstFailure := len(ops)
+	for _, we := range bulkErr.WriteErrors {
+		failed[we.Index] = we
+		if we.Index < firstFailure {
+			firstFailure = we.Index
+		}
+	}
+	results := make([]fiber.Map, len(ops))
+	for i := range ops {
+		we, isFailed := failed[i]
+		switch {
+		case isFailed:
+			results[i] = fiber.Map{"index": i, "status": "failed", "code": we.Code, "message": we.Message}
+		case ordered && i > firstFailure:
+			results[i] = fiber.Map{"index": i, "status": "skipped"}
+		default:
+			results[i] = fiber.Map{"index": i, "status": "applied"}
+		}
+	}
+
+	var matched, modified int64
+	if result != nil {
+		matched, modified = result.MatchedCount, result.ModifiedCount
+	}
+
+	status := 200
+	if len(failed) > 0 {
+		status = 207
+		loggerFrom(c).Warn("Some product updates failed.",
+			zap.Int("operations", len(ops)),
+			zap.Int("failed", len(failed)),
+			zap.Error(bulkErr))
+	}
+
+	return c.Status(status).JSON(fiber.Map{
+		"matched_count":  matched,
+		"modified_count": modified,
+		"results":        results,
+	})
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultPage  = 1
+	defaultLimit = 20
+	maxLimit     = 100
+
+	// maxPage keeps the skip of a page, (page-1)*limit, within an int64
+	maxPage = math.MaxInt64 / maxLimit
+)
+
+// parsePagination reads the page and limit query parameters, falling back to
+// the first page and def when they are absent or invalid, and capping page at
+// maxPage and limit at maxLimit.
+func parsePagination(c *fiber.Ctx, def int64) (int64, int64) {
+	page, err := strconv.ParseInt(c.Query("page"), 10, 64)
+	if err != nil || page < 1 {
+		page = defaultPage
+	}
+	if page > maxPage {
+		page = maxPage
+	}
+
+	limit, err := strconv.ParseInt(c.Query("limit"), 10, 64)
+	if err != nil || limit < 1 {
+		limit = def
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	return page, limit
+}
+
+// parseDefaultLimit parses a configured default page size, which must be a
+// positive integer no larger than maxLimit.
+func parseDefaultLimit(raw string) (int64, error) {
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("default limit must be a positive integer, got %q", raw)
+	}
+	if limit > maxLimit {
+		return 0, fmt.Errorf("default limit must be at most %d, got %d", maxLimit, limit)
+	}
+	return limit, nil
+}
+
+// buildPagination returns the pagination metadata for a page of a list with
+// total matches. A page past the end is still described, with has_next false,
+// so clients get an empty page rather than an error.
+func buildPagination(total, page, limit int64) fiber.Map {
+	totalPages := (total + limit - 1) / limit
+	return fiber.Map{
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+		"total_pages": totalPages,
+		"has_next":    page < totalPages,
+		"has_prev":    page > 1,
+	}
+}
+
+// setPaginationHeaders sets the X-Total-Count header and an RFC 5988 Link
+// header with the first, prev, next and last pages of a list with total
+// matches, for clients that page by headers rather than the body metadata.
+// The links keep the request's other query parameters.
+func setPaginationHeaders(c *fiber.Ctx, total, page, limit int64) {
+	c.Set("X-Total-Count", strconv.FormatInt(total, 10))
+
+	// The query string is already validated by the list handler, so an
+	// error here would only drop the other parameters from the links
+	query, _ := url.ParseQuery(string(c.Request().URI().QueryString()))
+	link := func(rel string, p int64) string {
+		query.Set("page", strconv.FormatInt(p, 10))
+		return fmt.Sprintf(`<%s%s?%s>; rel="%s"`, c.BaseURL(), c.Path(), query.Encode(), rel)
+	}
+
+	lastPage := (total + limit - 1) / limit
+	if lastPage < 1 {
+		lastPage = 1
+	}
+	links := []string{link("first", 1)}
+	if page > 1 {
+		links = append(links, link("prev", min(page-1, lastPage)))
+	}
+	if page < lastPage {
+		links = append(links, link("next", page+1))
+	}
+	links = append(links, link("last", lastPage))
+	c.Set(fiber.HeaderLink, strings.Join(links, ", "))
+}
+
+// parseObjectID parses a hex ObjectID like primitive.ObjectIDFromHex, but with
+// errors that say what is wrong with id, for returning to clients.
+func parseObjectID(id string) (primitive.ObjectID, error) {
+	switch {
+	case id == "":
+		return primitive.NilObjectID, errors.New("ID is empty")
+	case len(id) != 24:
+		return primitive.NilObjectID, fmt.Errorf("ID must be 24 hex characters, got %d", len(id))
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return primitive.NilObjectID, errors.New("ID contains characters other than hex digits")
+	}
+	return objectID, nil
+}
+
+// parseProjection turns a comma-separated list like "name,email" into a
+// projection document. Fields prefixed with "-" are excluded instead. _id is
+// kept unless explicitly excluded, and inclusions can't be mixed with other
+// exclusions. Fields not in allowed are rejected. An empty list means no
+// projection.
+func parseProjection(raw string, allowed map[string]bool) (bson.M, error) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	projection := bson.M{}
+	included, excluded := false, false
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		value := 1
+		if strings.HasPrefix(field, "-") {
+			field = field[1:]
+			value = 0
+		}
+		if field == "" || strings.HasPrefix(field, "$") {
+			return nil, fmt.Errorf("invalid field name %q", field)
+		}
+		if !allows(allowed, field) {
+			return nil, fmt.Errorf("field %q can't be selected", field)
+		}
+
+		// _id may be excluded alongside inclusions, so it doesn't count
+		if field != "_id" {
+			if value == 1 {
+				included = true
+			} else {
+				excluded = true
+			}
+		}
+		projection[field] = value
+	}
+
+	if included && excluded {
+		return nil, errors.New("cannot mix included and excluded fields")
+	}
+
+	return projection, nil
+}
+
+// parseSort turns a comma-separated list like "price,-name" into a sort
+// document. A leading "-" sorts descending; fields not in allowed are
+// rejected.
+func parseSort(raw string, allowed map[string]bool) (bson.D, error) {
+	sort := bson.D{}
+	for _, key := range splitList(raw) {
+		order := 1
+		if strings.HasPrefix(key, "-") {
+			key = key[1:]
+			order = -1
+		}
+		if !allowed[key] {
+			return nil, fmt.Errorf("can't sort by %q", key)
+		}
+		sort = append(sort, bson.E{Key: key, Value: order})
+	}
+	return sort, nil
+}
+
+// collationLocale matches the ICU locale names MongoDB collations take, like
+// "en" or "en_US", plus "simple" for binary comparison.
+var collationLocale = regexp.MustCompile(`^(simple|[a-z]{2,3}(_[A-Za-z0-9]+)*)$`)
+
+// parseCollation returns a case-insensitive collation for the locale in the
+// collation query parameter, or nil for the default binary comparison.
+func parseCollation(c *fiber.Ctx) (*options.Collation, error) {
+	locale := c.Query("collation")
+	if locale == "" {
+		return nil, nil
+	}
+	if !collationLocale.MatchString(locale) {
+		return nil, fmt.Errorf("invalid collation locale %q", locale)
+	}
+
+	// Strength 2 compares base letters and accents but not case
+	return &options.Collation{Locale: locale, Strength: 2}, nil
+}
+
+// Search modes, for how a search query has to match a field's value.
+const (
+	searchPrefix   = "prefix"
+	searchContains = "contains"
+	searchExact    = "exact"
+)
+
+// searchPattern returns the regex matching values the way mode says q must:
+// starting with it, containing it or being it. q is matched literally, so
+// regex metacharacters in it are escaped. Prefix patterns are anchored so
+// MongoDB can answer them from an index, for autocomplete; matched ignoring
+// case, it still reads every index key but not the documents.
+func searchPattern(q, mode string) (string, error) {
+	quoted := regexp.QuoteMeta(q)
+	switch mode {
+	case searchPrefix:
+		return "^" + quoted, nil
+	case searchContains:
+		return quoted, nil
+	case searchExact:
+		return "^" + quoted + "$", nil
+	}
+	return "", fmt.Errorf("mode must be %s, %s or %s, got %q", searchPrefix, searchContains, searchExact, mode)
+}
+
+// Kinds of values a filterable query parameter is coerced to.
+const (
+	filterString   = "string"
+	filterNumber   = "number"
+	filterObjectID = "objectid"
+	// filterLiteral is a string that may start with "$", for fields whose
+	// values legitimately do; other string values like that are rejected
+	filterLiteral = "literal"
+)
+
+// buildFilter builds an equality filter from the query parameters named in
+// allowed. Unknown query keys are ignored, and values that could be read as
+// operators are rejected.
+func buildFilter(c *fiber.Ctx, allowed map[string]string) (bson.M, error) {
+	filter := bson.M{}
+	for field, kind := range allowed {
+		raw := c.Query(field)
+		if raw == "" {
+			continue
+		}
+		if err := checkFilterValue(field, kind, raw); err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case filterNumber:
+			if n, err := strconv.ParseFloat(raw, 64); err == nil {
+				filter[field] = n
+				continue
+			}
+		case filterObjectID:
+			if objectID, err := primitive.ObjectIDFromHex(raw); err == nil {
+				filter[field] = objectID
+				continue
+			}
+		}
+		filter[field] = raw
+	}
+	return filter, nil
+}
+
+// parseDateRange builds a filter on field from the RFC3339 from and to query
+// parameters. Either bound may be omitted; with neither it returns nil.
+func parseDateRange(c *fiber.Ctx, field string) (bson.M, error) {
+	bounds := bson.M{}
+	for param, op := range map[string]string{"from": "$gte", "to": "$lte"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be an RFC3339 timestamp like 2024-01-02T15:04:05Z", param)
+		}
+		bounds[op] = t
+	}
+
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+	return bson.M{field: bounds}, nil
+}
+
+// parseNumberRange builds a filter on field from the minParam and maxParam
+// query parameters. Either bound may be omitted; with neither it returns nil.
+func parseNumberRange(c *fiber.Ctx, field, minParam, maxParam string) (bson.M, error) {
+	bounds := bson.M{}
+	for param, op := range map[string]string{minParam: "$gte", maxParam: "$lte"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a number", param)
+		}
+		bounds[op] = n
+	}
+
+	if len(bounds) == 0 {
+		return nil, nil
+	}
+	return bson.M{field: bounds}, nil
+}
+
+// parseSchemaFilter builds $exists and $type filters for auditing schema
+// drift. exists takes a comma-separated list of fields, each optionally
+// suffixed with ":false" to match documents missing it, e.g.
+// "phone,email:false". type takes field:type pairs like "age:string", where
+// the type is a MongoDB $type alias. Fields not in allowed are rejected. With
+// neither parameter it returns nil.
+func parseSchemaFilter(c *fiber.Ctx, allowed map[string]bool) (bson.M, error) {
+	filter := bson.M{}
+	cond := func(field string) (bson.M, error) {
+		if field == "" || strings.HasPrefix(field, "$") {
+			return nil, fmt.Errorf("invalid field name %q", field)
+		}
+		if !allows(allowed, field) {
+			return nil, fmt.Errorf("field %q can't be filtered on", field)
+		}
+		if existing, ok := filter[field].(bson.M); ok {
+			return existing, nil
+		}
+		conds := bson.M{}
+		filter[field] = conds
+		return conds, nil
+	}
+
+	for _, entry := range splitList(c.Query("exists")) {
+		field, rawExists, hasValue := strings.Cut(entry, ":")
+		exists := true
+		if hasValue {
+			var err error
+			if exists, err = strconv.ParseBool(rawExists); err != nil {
+				return nil, fmt.Errorf("exists for %s must be true or false", field)
+			}
+		}
+		conds, err := cond(field)
+		if err != nil {
+			return nil, err
+		}
+		conds["$exists"] = exists
+	}
+
+	for _, entry := range splitList(c.Query("type")) {
+		field, typeName, ok := strings.Cut(entry, ":")
+		if !ok {
+			return nil, fmt.Errorf("type filter %q must look like field:type", entry)
+		}
+		if !validTypeName(typeName) {
+			return nil, fmt.Errorf("unknown BSON type %q", typeName)
+		}
+		conds, err := cond(field)
+		if err != nil {
+			return nil, err
+		}
+		conds["$type"] = typeName
+	}
+
+	if len(filter) == 0 {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// validTypeName reports whether name is a $type alias MongoDB accepts.
+func validTypeName(name string) bool {
+	// "number" matches any numeric type, so has no single BSON type
+	if name == "number" {
+		return true
+	}
+	for _, alias := range bsonTypeNames {
+		if alias == name {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma-separated query parameter, dropping blank entries.
+func splitList(raw string) []string {
+	var items []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
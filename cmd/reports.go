@@ -0,0 +1,136 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// reportOrders returns the orders collection with the read preference from
+// the readPref query parameter applied, so heavy reports can be sent to
+// secondaries. Without the parameter the client's read preference is used.
+func (s *Store) reportOrders(c *fiber.Ctx) (*mongo.Collection, error) {
+	raw := c.Query("readPref")
+	if raw == "" {
+		return s.orders, nil
+	}
+
+	mode, err := readpref.ModeFromString(raw)
+	if err != nil {
+		return nil, err
+	}
+	rp, err := readpref.New(mode)
+	if err != nil {
+		return nil, err
+	}
+	return s.orders.Clone(options.Collection().SetReadPreference(rp))
+}
+
+func (s *Store) revenueByCustomer(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	orders, err := s.reportOrders(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid readPref parameter", err)
+	}
+
+	dateRange, err := parseDateRange(c, "created_at")
+	if err != nil {
+		return errorResponse(c, 400, "Invalid date range", err)
+	}
+
+	pipeline := mongo.Pipeline{}
+	if dateRange != nil {
+		pipeline = append(pipeline, bson.D{{Key: "$match", Value: dateRange}})
+	}
+	pipeline = append(pipeline,
+		bson.D{{Key: "$group", Value: bson.M{
+			"_id":   "$customer_id",
+			"total": bson.M{"$sum": "$total"},
+		}}},
+		bson.D{{Key: "$lookup", Value: bson.M{
+			"from":         s.customers.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "customer",
+		}}},
+		bson.D{{Key: "$project", Value: bson.M{
+			"_id":           0,
+			"customer_id":   "$_id",
+			"customer_name": bson.M{"$ifNull": bson.A{bson.M{"$first": "$customer.name"}, nil}},
+			"total":         1,
+		}}},
+		bson.D{{Key: "$sort", Value: bson.D{{Key: "total", Value: -1}}}},
+	)
+
+	cursor, err := orders.Aggregate(ctx, pipeline)
+	if err != nil {
+		return errorResponse(c, 500, "Error computing revenue by customer", err)
+	}
+	defer cursor.Close(ctx)
+
+	revenue := []bson.M{}
+	if err = cursor.All(ctx, &revenue); err != nil {
+		return errorResponse(c, 500, "Error computing revenue by customer", err)
+	}
+
+	return c.JSON(normalizeDocs(revenue))
+}
+
+const defaultTopProducts = 10
+
+func (s *Store) topProducts(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	orders, err := s.reportOrders(c)
+	if err != nil {
+		return errorResponse(c, 400, "Invalid readPref parameter", err)
+	}
+
+	limit := c.QueryInt("limit", defaultTopProducts)
+	if limit < 1 {
+		limit = defaultTopProducts
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$unwind", Value: "$items"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":      "$items.product_id",
+			"quantity": bson.M{"$sum": "$items.quantity"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "quantity", Value: -1}}}},
+		{{Key: "$limit", Value: limit}},
+		{{Key: "$lookup", Value: bson.M{
+			"from":         s.products.Name(),
+			"localField":   "_id",
+			"foreignField": "_id",
+			"as":           "product",
+		}}},
+		{{Key: "$project", Value: bson.M{
+			"_id":          0,
+			"product_id":   "$_id",
+			"product_name": bson.M{"$ifNull": bson.A{bson.M{"$first": "$product.name"}, nil}},
+			"quantity":     1,
+		}}},
+	}
+
+	cursor, err := orders.Aggregate(ctx, pipeline)
+	if err != nil {
+		return errorResponse(c, 500, "Error computing top products", err)
+	}
+	defer cursor.Close(ctx)
+
+	products := []bson.M{}
+	if err = cursor.All(ctx, &products); err != nil {
+		return errorResponse(c, 500, "Error computing top products", err)
+	}
+
+	return c.JSON(normalizeDocs(products))
+}
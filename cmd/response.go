@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+	"go.uber.org/zap"
+)
+
+// errorResponse writes a JSON error body with the given status. The error
+// detail is only included for client errors so server internals don't leak;
+// server errors are logged with the request-scoped logger instead.
+func errorResponse(c *fiber.Ctx, status int, msg string, err error) error {
+	// Server errors are logged in full since the client only sees msg
+	if status >= 500 {
+		loggerFrom(c).Error(msg+".",
+			zap.String("method", c.Method()),
+			zap.String("path", c.Path()),
+			zap.Error(err))
+	}
+
+	body := fiber.Map{}
+	if status >= 500 && mongoUnavailable(err) {
+		// Clients can retry these once MongoDB is reachable again, e.g. after
+		// a failover completes
+		status = 503
+		msg = "Database unavailable, please retry"
+		body["retry_after"] = retryAfterSeconds
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfterSeconds))
+	} else if status >= 500 && errors.Is(err, context.DeadlineExceeded) {
+		// A query that ran past the request deadline is a timeout, not a failure
+		status = 504
+		msg = "Request timed out"
+	}
+
+	body["error"] = msg
+	body["status"] = status
+	if err != nil && status < 500 {
+		body["detail"] = err.Error()
+	}
+	return c.Status(status).JSON(body)
+}
+
+// bodyParseError writes a 400 for an error from c.BodyParser. Malformed JSON
+// is reported with the byte offset the parser gave up at, and a value of the
+// wrong type with the field it was for.
+func bodyParseError(c *fiber.Ctx, err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return c.Status(400).JSON(fiber.Map{
+			"error":  "Malformed JSON",
+			"status": 400,
+			"detail": syntaxErr.Error(),
+			"offset": syntaxErr.Offset,
+		})
+	case errors.As(err, &typeErr):
+		return c.Status(400).JSON(fiber.Map{
+			"error":  "Invalid request body",
+			"status": 400,
+			"detail": fmt.Sprintf("%s must be %s, got %s", typeErr.Field, typeErr.Type, typeErr.Value),
+			"offset": typeErr.Offset,
+		})
+	case errors.Is(err, fiber.ErrUnprocessableEntity):
+		// Fiber's error for a missing or unsupported Content-Type
+		return errorResponse(c, 400, "Request body must be JSON", errors.New("set Content-Type: application/json"))
+	}
+	return errorResponse(c, 400, "Invalid request body", err)
+}
+
+// respond writes data wrapped in the standard {"data": ..., "meta": ...}
+// envelope, leaving meta out when nil. Clients that predate the envelope can
+// pass ?envelope=false to get data on its own.
+func respond(c *fiber.Ctx, data interface{}, meta fiber.Map) error {
+	if c.Query("envelope") == "false" {
+		return c.JSON(data)
+	}
+
+	body := fiber.Map{"data": data}
+	if meta != nil {
+		body["meta"] = meta
+	}
+	return c.JSON(body)
+}
+
+// setETag sets a weak ETag computed from doc, and reports whether it matches
+// the request's If-None-Match so the handler can answer 304 instead. The tag
+// hashes the whole document, so any update, including the updated_at it
+// sets, changes it.
+func setETag(c *fiber.Ctx, doc interface{}) (bool, error) {
+	// encoding/json sorts map keys, so equal documents hash the same
+	encoded, err := json.Marshal(doc)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(encoded)
+	etag := fmt.Sprintf(`W/"%x"`, sum[:16])
+	c.Set(fiber.HeaderETag, etag)
+
+	for _, candidate := range strings.Split(c.Get(fiber.HeaderIfNoneMatch), ",") {
+		// Weak comparison, so a strong form of the same tag matches too
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// retryAfterSeconds is how long clients are told to wait before retrying a
+// request that failed because MongoDB was unavailable.
+const retryAfterSeconds = 5
+
+// mongoUnavailable reports whether err means MongoDB couldn't be reached, as
+// opposed to the operation itself failing.
+func mongoUnavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var selectionErr topology.ServerSelectionError
+	return mongo.IsNetworkError(err) || errors.As(err, &selectionErr)
+}
+
+// normalizeDocs normalizes each of docs in place for JSON output.
+func normalizeDocs(docs []bson.M) []bson.M {
+	for _, doc := range docs {
+		normalizeDoc(doc)
+	}
+	return docs
+}
+
+// normalizeDoc rewrites doc in place so BSON-specific values render as plain
+// JSON, recursing into subdocuments and arrays.
+func normalizeDoc(doc bson.M) bson.M {
+	for key, value := range doc {
+		doc[key] = normalizeValue(value)
+	}
+	return doc
+}
+
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.ObjectID:
+		return v.Hex()
+	case primitive.Decimal128:
+		// As a string, since a JSON number would be read back as a float
+		return v.String()
+	case bson.M:
+		return normalizeDoc(v)
+	case bson.D:
+		for i := range v {
+			v[i].Value = normalizeValue(v[i].Value)
+		}
+	case bson.A:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeValue(v[i])
+		}
+	}
+	return value
+}
+
+// dupKeyPattern extracts the first field of the "dup key: { field: ... }"
+// part of a duplicate key error message, for servers that don't report the
+// key pattern separately.
+var dupKeyPattern = regexp.MustCompile(`dup key: \{ ?"?([^":\s]+)"?:`)
+
+// duplicateKeyField returns the field that caused a duplicate key error, or
+// an empty string when it can't be determined.
+func duplicateKeyField(err error) string {
+	var raws []bson.Raw
+	var writeErr mongo.WriteException
+	var bulkErr mongo.BulkWriteException
+	var cmdErr mongo.CommandError
+	switch {
+	case errors.As(err, &writeErr):
+		for _, we := range writeErr.WriteErrors {
+			raws = append(raws, we.Raw)
+		}
+	case errors.As(err, &bulkErr):
+		for _, we := range bulkErr.WriteErrors {
+			raws = append(raws, we.Raw)
+		}
+	case errors.As(err, &cmdErr):
+		raws = append(raws, cmdErr.Raw)
+	}
+
+	for _, raw := range raws {
+		if pattern, ok := raw.Lookup("keyPattern").DocumentOK(); ok {
+			if elems, err := pattern.Elements(); err == nil && len(elems) > 0 {
+				return elems[0].Key()
+			}
+		}
+	}
+
+	if m := dupKeyPattern.FindStringSubmatch(err.Error()); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// duplicateKeyResponse writes a 409 for a duplicate key error on a resource,
+// naming the conflicting field instead of echoing the raw server error.
+func duplicateKeyResponse(c *fiber.Ctx, resource string, err error) error {
+	field := duplicateKeyField(err)
+	if field == "" {
+		return errorResponse(c, 409, resource+" already exists", nil)
+	}
+
+	return c.Status(409).JSON(fiber.Map{
+		"error":  fmt.Sprintf("A %s with this %s already exists", strings.ToLower(resource), field),
+		"status": 409,
+		"field":  field,
+	})
+}
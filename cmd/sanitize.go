@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// errOperatorInjection is returned for untrusted input that would be read as
+// a MongoDB operator, such as a $where key or a "$regex" value.
+var errOperatorInjection = errors.New("operators are not allowed")
+
+// checkFilterValue rejects a query parameter value for field that starts with
+// "$", since the filter builders pass values on as they are. Fields of the
+// filterLiteral kind are trusted to hold such values.
+func checkFilterValue(field, kind, raw string) error {
+	if kind != filterLiteral && strings.HasPrefix(raw, "$") {
+		return fmt.Errorf("%w: %s may not start with $", errOperatorInjection, field)
+	}
+	return nil
+}
+
+// checkDocument rejects a request body document with a key starting with "$"
+// at any depth, so update handlers that set fields from the body can't be
+// turned into arbitrary operators.
+func checkDocument(doc map[string]interface{}) error {
+	for key, value := range doc {
+		if strings.HasPrefix(key, "$") {
+			return fmt.Errorf("%w: field %q", errOperatorInjection, key)
+		}
+		if err := checkValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkValue(value interface{}) error {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return checkDocument(v)
+	case []interface{}:
+		for _, item := range v {
+			if err := checkValue(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// codeOperators are the query operators that run JavaScript on the server,
+// which client-supplied queries may never use.
+var codeOperators = map[string]bool{
+	"$where":       true,
+	"$function":    true,
+	"$accumulator": true,
+}
+
+// checkQuery rejects a client-supplied query document that uses any of the
+// codeOperators at any depth. Other operators are allowed, for endpoints that
+// take whole queries rather than field values.
+func checkQuery(query map[string]interface{}) error {
+	for key, value := range query {
+		if codeOperators[key] {
+			return fmt.Errorf("%w: %s", errOperatorInjection, key)
+		}
+		if err := checkQueryValue(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkQueryValue(value interface{}) error {
+	switch v := value.(type) {
+	case bson.M:
+		return checkQuery(v)
+	case map[string]interface{}:
+		return checkQuery(v)
+	case bson.D:
+		for _, e := range v {
+			if codeOperators[e.Key] {
+				return fmt.Errorf("%w: %s", errOperatorInjection, e.Key)
+			}
+			if err := checkQueryValue(e.Value); err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, item := range v {
+			if err := checkQueryValue(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := checkQueryValue(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestCheckFilterValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		raw     string
+		wantErr bool
+	}{
+		{"plain string", filterString, "Ada", false},
+		{"dollar inside string", filterString, "US$5", false},
+		{"number", filterNumber, "19.99", false},
+		{"object id", filterObjectID, "5f1d7f0b8c9d1b2a3c4d5e6f", false},
+		{"where operator", filterString, "$where", true},
+		{"regex operator", filterString, "$regex", true},
+		{"ne operator", filterString, "$ne", true},
+		{"operator as number", filterNumber, "$gt", true},
+		{"operator as object id", filterObjectID, "$exists", true},
+		{"literal dollar value", filterLiteral, "$5 off", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkFilterValue("field", tt.kind, tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkFilterValue(%q, %q) = %v, want error %v", tt.kind, tt.raw, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errOperatorInjection) {
+				t.Errorf("error %v is not errOperatorInjection", err)
+			}
+		})
+	}
+}
+
+func TestCheckDocument(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     map[string]interface{}
+		wantErr bool
+	}{
+		{"plain fields", map[string]interface{}{"name": "Ada", "price": 1.5}, false},
+		{"dollar in value", map[string]interface{}{"name": "$where"}, false},
+		{"nested plain fields", map[string]interface{}{
+			"address": map[string]interface{}{"city": "London"},
+			"tags":    []interface{}{"a", map[string]interface{}{"b": 1}},
+		}, false},
+		{"top-level where", map[string]interface{}{"$where": "sleep(1000)"}, true},
+		{"top-level set", map[string]interface{}{"$set": map[string]interface{}{"admin": true}}, true},
+		{"nested operator", map[string]interface{}{
+			"price": map[string]interface{}{"$gt": 0},
+		}, true},
+		{"deeply nested operator", map[string]interface{}{
+			"a": map[string]interface{}{"b": map[string]interface{}{"$ne": nil}},
+		}, true},
+		{"operator in array", map[string]interface{}{
+			"tags": []interface{}{map[string]interface{}{"$regex": "^(a+)+$"}},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDocument(tt.doc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkDocument(%v) = %v, want error %v", tt.doc, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errOperatorInjection) {
+				t.Errorf("error %v is not errOperatorInjection", err)
+			}
+		})
+	}
+}
+
+func TestCheckQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   bson.M
+		wantErr bool
+	}{
+		{"equality", bson.M{"category": "books"}, false},
+		{"comparison operators", bson.M{"price": bson.M{"$gt": 10, "$lte": 20}}, false},
+		{"logical operators", bson.M{"$or": bson.A{bson.M{"a": 1}, bson.M{"b": bson.M{"$in": bson.A{1, 2}}}}}, false},
+		{"where", bson.M{"$where": "this.price > 0"}, true},
+		{"function", bson.M{"$expr": bson.M{"$function": bson.M{"body": "return true", "args": bson.A{}, "lang": "js"}}}, true},
+		{"accumulator", bson.M{"$expr": bson.M{"$accumulator": bson.M{}}}, true},
+		{"where in or", bson.M{"$or": bson.A{bson.M{"a": 1}, bson.M{"$where": "sleep(1000)"}}}, true},
+		{"where in plain map", bson.M{"a": map[string]interface{}{"$where": "1"}}, true},
+		{"where in slice", bson.M{"$and": []interface{}{bson.M{"$where": "1"}}}, true},
+		{"where in ordered document", bson.M{"$and": bson.A{bson.D{{Key: "$where", Value: "1"}}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkQuery(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkQuery(%v) = %v, want error %v", tt.query, err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, errOperatorInjection) {
+				t.Errorf("error %v is not errOperatorInjection", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.uber.org/zap"
+)
+
+// seedData is the layout of a seed file: the documents to insert into each
+// collection, in MongoDB extended JSON so IDs and dates keep their types.
+type seedData struct {
+	Customers []interface{} `bson:"customers"`
+	Products  []interface{} `bson:"products"`
+	Orders    []interface{} `bson:"orders"`
+}
+
+// seed inserts the documents in the seed file at path into each collection
+// that is still empty. Collections that already have data are left alone, so
+// this is safe to run on every startup.
+func (s *Store) seed(ctx context.Context, logger *zap.Logger, path string) error {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var data seedData
+	if err := bson.UnmarshalExtJSON(raw, false, &data); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+
+	seeds := []struct {
+		coll *mongo.Collection
+		docs []interface{}
+	}{
+		{s.customers, data.Customers},
+		{s.products, data.Products},
+		{s.orders, data.Orders},
+	}
+	for _, seed := range seeds {
+		if len(seed.docs) == 0 {
+			continue
+		}
+
+		count, err := seed.coll.CountDocuments(ctx, bson.D{}, options.Count().SetLimit(1))
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			logger.Info("Collection already has data, skipping seeding.", zap.String("collection", seed.coll.Name()))
+			continue
+		}
+
+		result, err := seed.coll.InsertMany(ctx, seed.docs)
+		if err != nil {
+			return fmt.Errorf("seeding %s: %w", seed.coll.Name(), err)
+		}
+		logger.Info("Seeded collection.",
+			zap.String("collection", seed.coll.Name()),
+			zap.Int("count", len(result.InsertedIDs)))
+	}
+
+	return nil
+}
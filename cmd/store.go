@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Store holds the MongoDB client and the collections the API serves. Route
+// handlers are methods on Store so they share its connections.
+type Store struct {
+	client    *mongo.Client
+	database  *mongo.Database
+	customers *mongo.Collection
+	products  *mongo.Collection
+	orders    *mongo.Collection
+
+	// softDelete makes deletes set deleted_at instead of removing documents
+	softDelete bool
+	// customerExportFields are the columns of the customer CSV export
+	customerExportFields []string
+	// defaultLimits are the page sizes of the collection lists when clients
+	// don't ask for one, keyed by collection name
+	defaultLimits map[string]int64
+
+	// health is the background MongoDB health monitor, nil when off
+	health *healthMonitor
+	// cache holds the responses of expensive read endpoints, nil when off
+	cache *responseCache
+
+	// tenants are the stores of the other databases requests may select,
+	// keyed by database name
+	tenants map[string]*Store
+}
+
+// NewStore returns a Store backed by the named database on client.
+func NewStore(client *mongo.Client, dbName string) *Store {
+	return (&Store{client: client}).withDatabase(dbName)
+}
+
+// withDatabase returns a copy of s backed by the named database instead,
+// keeping its settings.
+func (s *Store) withDatabase(dbName string) *Store {
+	t := *s
+	t.database = s.client.Database(dbName)
+	t.customers = t.database.Collection("customers")
+	t.products = t.database.Collection("products")
+	t.orders = t.database.Collection("orders")
+	t.tenants = nil
+	return &t
+}
+
+// addTenants allows requests to select each of the named databases with the
+// X-Tenant-DB header. The tenant stores copy the settings of s, so call it
+// once s is fully configured.
+func (s *Store) addTenants(dbNames []string) {
+	s.tenants = make(map[string]*Store, len(dbNames))
+	for _, name := range dbNames {
+		s.tenants[name] = s.withDatabase(name)
+	}
+}
+
+// tenantHandler adapts h to a route handler run against the store of the
+// database named by the X-Tenant-DB header, or against s when the header is
+// absent. Databases not added with addTenants are rejected with a 400.
+func (s *Store) tenantHandler(h func(*Store, *fiber.Ctx) error) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		name := c.Get("X-Tenant-DB")
+		if name == "" {
+			return h(s, c)
+		}
+		tenant, ok := s.tenants[name]
+		if !ok {
+			return errorResponse(c, 400, "Unknown tenant", fmt.Errorf("unknown tenant database %q", name))
+		}
+		return h(tenant, c)
+	}
+}
+
+// registerRoutes registers the API routes against s. Data routes are served
+// from the tenant database the request selects, see tenantHandler.
+func (s *Store) registerRoutes(app *fiber.App) {
+	t := s.tenantHandler
+	cached := s.cache.handler
+
+	app.Use(s.cache.invalidateOnWrite)
+
+	app.Get("/healthz", s.healthCheck)
+	app.Get("/metrics", metricsHandler())
+
+	app.Get("/api/system/info", requireAdmin, s.systemInfo)
+	app.Get("/api/fields", cached("customers", "products", "orders"), t((*Store).listFields))
+
+	// Routes are matched in the order they're registered, so these come
+	// before the /:id routes below, which would otherwise take "indexes" for
+	// an ID.
+	app.Post("/api/:collection/aggregate", requireAdmin, t((*Store).runAggregation))
+	app.Post("/api/:collection/explain", requireAdmin, t((*Store).explainQuery))
+	app.Get("/api/:collection/indexes", requireAdmin, t((*Store).listIndexes))
+	app.Post("/api/:collection/rename-field", requireAdmin, t((*Store).renameField))
+
+	// The customer, product and order lists, see listSpecs
+	app.Get("/api/:collection", t((*Store).getAll))
+
+	app.Get("/api/customers/search", t((*Store).searchCustomers))
+	app.Get("/api/customers/count", t((*Store).countCustomers))
+	app.Get("/api/customers/export.csv", t((*Store).exportCustomersCSV))
+	app.Get("/api/customers/stream", t((*Store).streamCustomers))
+	app.Get("/api/customers/:id", t((*Store).getCustomerByID))
+	app.Get("/api/customers/:id/orders", t((*Store).getCustomerOrders))
+	app.Post("/api/customers", t((*Store).createCustomer))
+	app.Put("/api/customers/:id", t((*Store).replaceCustomer))
+	app.Patch("/api/customers/:id", t((*Store).patchCustomer))
+	app.Delete("/api/customers/:id", t((*Store).deleteCustomer))
+	app.Get("/api/products/count", t((*Store).countProducts))
+	app.Get("/api/products/stats", t((*Store).productStats))
+	app.Post("/api/products/bulk", t((*Store).bulkCreateProducts))
+	app.Post("/api/products/batch", t((*Store).getProductsByIDs))
+	app.Post("/api/products/bulk-update", t((*Store).bulkUpdateProducts))
+	app.Put("/api/products/by-sku/:sku", t((*Store).upsertProductBySKU))
+	app.Get("/api/products/distinct/:field", t((*Store).distinctProducts))
+	app.Get("/api/products/:id", t((*Store).getProductByID))
+	app.Put("/api/products/:id", t((*Store).updateProduct))
+	app.Patch("/api/products/:id", t((*Store).updateProduct))
+	app.Get("/api/orders/count", t((*Store).countOrders))
+	app.Post("/api/orders", t((*Store).createOrder))
+	app.Post("/api/orders/validate", t((*Store).validateOrder))
+	app.Get("/api/orders/distinct/:field", t((*Store).distinctOrders))
+	app.Get("/api/orders/live", requireWebSocket, t((*Store).liveOrders))
+	app.Get("/api/orders/:id", t((*Store).getOrderByID))
+	app.Get("/api/orders/:id/items", t((*Store).getOrderItems))
+	app.Get("/api/orders/:id/products", t((*Store).getOrderProducts))
+	app.Patch("/api/orders/:id/status", t((*Store).updateOrderStatus))
+	app.Patch("/api/orders/:id/items/:itemId", t((*Store).updateOrderItem))
+	app.Delete("/api/orders", t((*Store).deleteOrdersByFilter))
+	app.Delete("/api/orders/:id", t((*Store).deleteOrder))
+
+	app.Get("/api/reports/revenue-by-customer", cached("orders", "customers"), t((*Store).revenueByCustomer))
+	app.Get("/api/reports/top-products", cached("orders", "products"), t((*Store).topProducts))
+
+}
+
+// pageSize returns the page size of the named collection's lists when
+// the client doesn't ask for one.
+func (s *Store) pageSize(collection string) int64 {
+	if limit, ok := s.defaultLimits[collection]; ok {
+		return limit
+	}
+	return defaultLimit
+}
+
+// requestContext derives a context for MongoDB calls made while handling c,
+// bounded by the configured request timeout.
+func requestContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.UserContext(), requestTimeout)
+}
+
+// countDocuments responds with the number of documents in coll matching filter.
+func countDocuments(c *fiber.Ctx, coll *mongo.Collection, filter bson.M) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	excludeDeleted(c, filter)
+	count, err := coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return errorResponse(c, 500, "Error counting "+coll.Name(), err)
+	}
+
+	return c.JSON(fiber.Map{"count": count})
+}
+
+// distinctValues responds with the distinct values of the field in the path
+// across coll. Only fields in allowed may be queried, so clients can't scan
+// arbitrary keys.
+func distinctValues(c *fiber.Ctx, coll *mongo.Collection, allowed map[string]bool) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	field := c.Params("field")
+	if !allowed[field] {
+		return errorResponse(c, 400, "Field is not available for distinct values", fmt.Errorf("unknown field %q", field))
+	}
+
+	filter := bson.M{}
+	excludeDeleted(c, filter)
+	values, err := coll.Distinct(ctx, field, filter)
+	if err != nil {
+		return errorResponse(c, 500, "Error finding distinct "+coll.Name()+" values", err)
+	}
+
+	return respond(c, normalizeValue(bson.A(values)), fiber.Map{"count": len(values)})
+}
+
+// excludeDeleted adds a condition to filter that skips soft-deleted
+// documents, unless the request asks for them with ?includeDeleted=true.
+func excludeDeleted(c *fiber.Ctx, filter bson.M) {
+	if c.Query("includeDeleted") == "true" {
+		return
+	}
+	filter["deleted_at"] = bson.M{"$exists": false}
+}
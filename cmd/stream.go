@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bufio"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// streamFlushEvery is how many documents a stream writes between flushes to
+// the client.
+const streamFlushEvery = 100
+
+// bodyStream is the writer a streamBody callback writes the response to.
+type bodyStream struct {
+	*bufio.Writer
+	// logger is the request's logger, since the fiber.Ctx can't be used
+	logger  *zap.Logger
+	written int
+}
+
+// streamBody has write produce the response body once the handler returns,
+// for responses too large or long-lived to buffer. Fiber recycles the
+// fiber.Ctx by then, so write must not touch c; anything it needs from the
+// request has to be read beforehand. Headers must be set before calling it.
+func streamBody(c *fiber.Ctx, write func(w *bodyStream)) {
+	logger := loggerFrom(c)
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		write(&bodyStream{Writer: w, logger: logger})
+	})
+}
+
+// flush sends what has been written so far on to the client. It reports false
+// once the client has gone away, after which writing should stop.
+func (w *bodyStream) flush() bool {
+	return w.Flush() == nil
+}
+
+// wrote counts one more document written, flushing after every
+// streamFlushEvery of them. Like flush, it reports false once the client has
+// gone away.
+func (w *bodyStream) wrote() bool {
+	w.written++
+	if w.written%streamFlushEvery != 0 {
+		return true
+	}
+	return w.flush()
+}
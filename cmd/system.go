@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// systemInfo responds with the version, uptime and connection counts of the
+// MongoDB server the API is connected to, for telling which cluster a
+// deployment points at. Only those fields are copied out of buildInfo and
+// serverStatus, leaving out hosts, build flags and the like. It must be
+// routed behind requireAdmin.
+func (s *Store) systemInfo(c *fiber.Ctx) error {
+	ctx, cancel := requestContext(c)
+	defer cancel()
+
+	admin := s.client.Database("admin")
+
+	var build struct {
+		Version    string   `bson:"version"`
+		GitVersion string   `bson:"gitVersion"`
+		Modules    []string `bson:"modules"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&build); err != nil {
+		return errorResponse(c, 500, "Error getting server build info", err)
+	}
+
+	// Every section but these is left out, so the server skips computing them
+	var status struct {
+		Process     string  `bson:"process"`
+		Uptime      float64 `bson:"uptime"`
+		Connections struct {
+			Current      int64 `bson:"current"`
+			Available    int64 `bson:"available"`
+			TotalCreated int64 `bson:"totalCreated"`
+		} `bson:"connections"`
+		StorageEngine struct {
+			Name string `bson:"name"`
+		} `bson:"storageEngine"`
+	}
+	statusCmd := bson.D{
+		{Key: "serverStatus", Value: 1},
+		{Key: "asserts", Value: 0},
+		{Key: "metrics", Value: 0},
+		{Key: "opcounters", Value: 0},
+		{Key: "wiredTiger", Value: 0},
+	}
+	if err := admin.RunCommand(ctx, statusCmd).Decode(&status); err != nil {
+		return errorResponse(c, 500, "Error getting server status", err)
+	}
+
+	if build.Modules == nil {
+		build.Modules = []string{}
+	}
+
+	return respond(c, fiber.Map{
+		"version":        build.Version,
+		"git_version":    build.GitVersion,
+		"modules":        build.Modules,
+		"process":        status.Process,
+		"uptime_seconds": int64(status.Uptime),
+		"storage_engine": status.StorageEngine.Name,
+		"connections": fiber.Map{
+			"current":       status.Connections.Current,
+			"available":     status.Connections.Available,
+			"total_created": status.Connections.TotalCreated,
+		},
+	}, nil)
+}
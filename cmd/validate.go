@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	// Report fields by their JSON names, the ones clients actually send
+	v.RegisterTagNameFunc(jsonName)
+	return v
+}
+
+// jsonName returns the JSON name of a struct field, or "" for fields left out
+// of JSON.
+func jsonName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// fieldError describes a single field that failed validation.
+type fieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Types a field rule expects its value to have.
+const (
+	ruleString = "string"
+	ruleNumber = "number"
+)
+
+// fieldRule is the validation rule for one field of a schemaless document:
+// the type its value must have and the validate tag it is checked against.
+// The type is checked first since the validator panics on values of a type
+// its tag doesn't apply to, such as a bool against gt.
+type fieldRule struct {
+	kind string
+	tag  string
+}
+
+// productRules holds the validation rules for product documents, which are
+// stored schemaless so have no struct to carry tags.
+var productRules = map[string]fieldRule{
+	"name":     {ruleString, "required,max=200"},
+	"price":    {ruleNumber, "required,gt=0"},
+	"stock":    {ruleNumber, "omitempty,gte=0"},
+	"category": {ruleString, "omitempty,max=100"},
+}
+
+// productDecimalFields are the product fields stored as Decimal128 when sent
+// as decimal strings like "19.99", so money keeps its exact value instead of
+// picking up float rounding.
+var productDecimalFields = []string{"price"}
+
+// customerRules holds the Customer struct's validation rules keyed by JSON
+// name, for checking partial updates.
+var customerRules = structRules(Customer{})
+
+// structRules returns the rules of v's fields keyed by JSON name, from their
+// validate tags and types.
+func structRules(v interface{}) map[string]fieldRule {
+	rules := map[string]fieldRule{}
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+		kind := ""
+		switch field.Type.Kind() {
+		case reflect.String:
+			kind = ruleString
+		case reflect.Int, reflect.Int32, reflect.Int64, reflect.Float32, reflect.Float64:
+			kind = ruleNumber
+		}
+		rules[jsonName(field)] = fieldRule{kind: kind, tag: tag}
+	}
+	return rules
+}
+
+// valueKind returns the rule type of a decoded document value, or "" for
+// values that are neither strings nor numbers.
+func valueKind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return ruleString
+	case float64, float32, int, int32, int64, primitive.Decimal128:
+		return ruleNumber
+	}
+	return ""
+}
+
+// validateStruct validates v against its validate tags.
+func validateStruct(v interface{}) []fieldError {
+	return fieldErrors("", validate.Struct(v))
+}
+
+// validateFields validates the fields of a document against rules. For
+// partial updates only the fields present are checked; otherwise fields with
+// a required rule must be present too. prefix is prepended to field names,
+// for reporting which document of a batch failed.
+func validateFields(prefix string, fields map[string]interface{}, rules map[string]fieldRule, partial bool) []fieldError {
+	var errs []fieldError
+	for field, rule := range rules {
+		value, ok := fields[field]
+		if !ok {
+			if !partial && strings.Contains(rule.tag, "required") {
+				errs = append(errs, fieldError{Field: prefix + field, Rule: "required", Message: "is required"})
+			}
+			continue
+		}
+		// null is left to the tag, which the validator handles safely
+		if value != nil && rule.kind != "" && valueKind(value) != rule.kind {
+			errs = append(errs, fieldError{Field: prefix + field, Rule: "type", Message: "must be a " + rule.kind})
+			continue
+		}
+		// The validator has no Decimal128 support, and the float value is
+		// close enough to check bounds like gt=0
+		if d, ok := value.(primitive.Decimal128); ok {
+			value, _ = strconv.ParseFloat(d.String(), 64)
+		}
+		errs = append(errs, fieldErrors(prefix+field, validate.Var(value, rule.tag))...)
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Field < errs[j].Field })
+	return errs
+}
+
+// validateProduct validates the fields of a product document against
+// productRules like validateFields, after converting the decimal strings in
+// its productDecimalFields to Decimal128.
+func validateProduct(prefix string, fields map[string]interface{}, partial bool) []fieldError {
+	if errs := parseDecimals(prefix, fields, productDecimalFields); len(errs) > 0 {
+		return errs
+	}
+	return validateFields(prefix, fields, productRules, partial)
+}
+
+// parseDecimals replaces the string values of decimalFields in fields with
+// the Decimal128 they spell, reporting the ones that aren't decimal numbers.
+// Other values are left as they are.
+func parseDecimals(prefix string, fields map[string]interface{}, decimalFields []string) []fieldError {
+	var errs []fieldError
+	for _, field := range decimalFields {
+		raw, ok := fields[field].(string)
+		if !ok {
+			continue
+		}
+		d, err := primitive.ParseDecimal128(raw)
+		if err != nil {
+			errs = append(errs, fieldError{Field: prefix + field, Rule: "decimal", Message: "must be a decimal number"})
+			continue
+		}
+		fields[field] = d
+	}
+	return errs
+}
+
+// fieldErrors converts a validator error into fieldErrors. Struct errors are
+// named by their path below the top-level struct; errors from validating a
+// single value are all reported against name.
+func fieldErrors(name string, err error) []fieldError {
+	var validationErrs validator.ValidationErrors
+	if !errors.As(err, &validationErrs) {
+		return nil
+	}
+
+	errs := make([]fieldError, 0, len(validationErrs))
+	for _, fe := range validationErrs {
+		field := name
+		if field == "" {
+			// Drop the struct name, e.g. Order.items[0].quantity
+			field = fe.Namespace()
+			if i := strings.Index(field, "."); i >= 0 {
+				field = field[i+1:]
+			}
+		}
+		errs = append(errs, fieldError{Field: field, Rule: fe.Tag(), Message: ruleMessage(fe)})
+	}
+	return errs
+}
+
+// ruleMessage describes a failed rule in words.
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "max", "min":
+		bound := "at most"
+		if fe.Tag() == "min" {
+			bound = "at least"
+		}
+		unit := ""
+		switch fe.Kind() {
+		case reflect.String:
+			unit = " characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			unit = " items"
+		}
+		return fmt.Sprintf("must be %s %s%s", bound, fe.Param(), unit)
+	case "gt":
+		return fmt.Sprintf("must be greater than %s", fe.Param())
+	case "gte":
+		return fmt.Sprintf("must be %s or more", fe.Param())
+	}
+	return fmt.Sprintf("failed the %s rule", fe.Tag())
+}
+
+// validationResponse writes a 422 listing the fields that failed validation.
+func validationResponse(c *fiber.Ctx, errs []fieldError) error {
+	return c.Status(422).JSON(fiber.Map{
+		"error":  "Validation failed",
+		"status": 422,
+		"fields": errs,
+	})
+}
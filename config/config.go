@@ -1,19 +1,292 @@
 package config
 
 import (
-	"log"
+	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
-func LoadEnv() {
-	err := godotenv.Load("../.devcontainer/.env")
-	if err != nil {
-		log.Fatalf("Error loading .env file")
+const envFile = "../.devcontainer/.env"
+
+// LoadEnv loads variables from the .env file without overriding ones already
+// set. A missing file is reported as an error wrapping fs.ErrNotExist, which
+// callers can treat as non-fatal when the environment is provided directly.
+func LoadEnv() error {
+	if err := godotenv.Load(envFile); err != nil {
+		return fmt.Errorf("loading %s: %w", envFile, err)
 	}
+	return nil
 }
 
 func GetMongoDB_URL() string {
 	return os.Getenv("MONGODB_URL")
 }
+
+func GetMongoDB_Name() string {
+	if name := os.Getenv("MONGODB_DATABASE"); name != "" {
+		return name
+	}
+	return "firstDB"
+}
+
+// GetAppName returns the app name the MongoDB client identifies itself with.
+func GetAppName() string {
+	if name := os.Getenv("APP_NAME"); name != "" {
+		return name
+	}
+	return "mongo-go"
+}
+
+// GetMongoDB_MaxPoolSize defaults to the driver's own default of 100.
+// Zero means no limit.
+func GetMongoDB_MaxPoolSize() uint64 {
+	return getUint("MONGODB_MAX_POOL_SIZE", 100)
+}
+
+// GetMongoDB_MinPoolSize defaults to the driver's own default of 0.
+func GetMongoDB_MinPoolSize() uint64 {
+	return getUint("MONGODB_MIN_POOL_SIZE", 0)
+}
+
+// GetSoftDelete reports whether deletes should only mark documents with a
+// deleted_at timestamp instead of removing them.
+func GetSoftDelete() bool {
+	enabled, _ := strconv.ParseBool(os.Getenv("SOFT_DELETE"))
+	return enabled
+}
+
+// GetCustomerExportFields returns the customer fields included in the CSV
+// export, in column order, from a comma-separated list.
+func GetCustomerExportFields() []string {
+	fields := getList("CUSTOMER_EXPORT_FIELDS")
+	if len(fields) == 0 {
+		return []string{"_id", "name", "email", "phone", "created_at", "updated_at"}
+	}
+	return fields
+}
+
+// GetAPIKey returns the key clients must send in the X-API-Key header. Empty
+// disables authentication.
+func GetAPIKey() string {
+	return os.Getenv("API_KEY")
+}
+
+// GetJWTSecret returns the HS256 secret JWTs are verified with. When set, JWT
+// authentication is used instead of the API key.
+func GetJWTSecret() string {
+	return os.Getenv("JWT_SECRET")
+}
+
+// GetRateLimitPerMinute returns how many requests a minute each client may
+// make, defaulting to 600. Zero disables rate limiting.
+func GetRateLimitPerMinute() int {
+	return int(getUint("RATE_LIMIT_PER_MINUTE", 600))
+}
+
+// GetTenantDatabases returns the databases besides the default one that
+// requests may select with the X-Tenant-DB header, from a comma-separated
+// list.
+func GetTenantDatabases() []string {
+	return getList("TENANT_DATABASES")
+}
+
+// GetLogLevel returns the zap log level name, such as "debug", defaulting to
+// "info".
+func GetLogLevel() string {
+	if level := strings.TrimSpace(os.Getenv("LOG_LEVEL")); level != "" {
+		return level
+	}
+	return "info"
+}
+
+// GetCompressionLevel returns the response compression level: "disabled",
+// "default", "speed" or "best". Unknown values fall back to "default".
+func GetCompressionLevel() string {
+	switch level := strings.ToLower(os.Getenv("COMPRESSION_LEVEL")); level {
+	case "disabled", "speed", "best":
+		return level
+	}
+	return "default"
+}
+
+// GetSeedFile returns the path of a JSON file to seed empty collections from
+// at startup, for local development. Empty disables seeding.
+func GetSeedFile() string {
+	return os.Getenv("SEED_FILE")
+}
+
+// GetMaxBodySize returns the largest request body accepted, in bytes,
+// defaulting to 4MB.
+func GetMaxBodySize() int {
+	size := getUint("MAX_BODY_SIZE", 4<<20)
+	if size == 0 {
+		return 4 << 20
+	}
+	return int(size)
+}
+
+func GetServerPort() string {
+	if port := os.Getenv("PORT"); port != "" {
+		return port
+	}
+	return "8090"
+}
+
+// GetCORSOrigins returns the comma-separated list of allowed origins,
+// defaulting to "*" for development.
+func GetCORSOrigins() string {
+	if origins := strings.TrimSpace(os.Getenv("CORS_ORIGINS")); origins != "" {
+		return origins
+	}
+	return "*"
+}
+
+// GetAccessLogFormat returns "json" for structured access logs through zap,
+// the default, or "console" for Fiber's plain text logger.
+func GetAccessLogFormat() string {
+	if strings.ToLower(os.Getenv("ACCESS_LOG_FORMAT")) == "console" {
+		return "console"
+	}
+	return "json"
+}
+
+func GetMongoDB_ConnectAttempts() int {
+	attempts := getUint("MONGODB_CONNECT_ATTEMPTS", 5)
+	if attempts == 0 {
+		return 1
+	}
+	return int(attempts)
+}
+
+func GetMongoDB_ConnectBackoff() time.Duration {
+	return getDuration("MONGODB_CONNECT_BACKOFF", time.Second)
+}
+
+// GetMongoDB_ReadPreference returns the read preference mode for the client,
+// such as "primary" or "secondaryPreferred", defaulting to "primary".
+func GetMongoDB_ReadPreference() string {
+	if mode := strings.TrimSpace(os.Getenv("MONGODB_READ_PREFERENCE")); mode != "" {
+		return mode
+	}
+	return "primary"
+}
+
+// GetMongoDB_WriteConcern returns the write concern "w" value for the client,
+// "majority" or a number of nodes. Empty leaves the server default in place.
+func GetMongoDB_WriteConcern() string {
+	return strings.TrimSpace(os.Getenv("MONGODB_WRITE_CONCERN"))
+}
+
+func GetMongoDB_ConnectTimeout() time.Duration {
+	return getDuration("MONGODB_CONNECT_TIMEOUT", 10*time.Second)
+}
+
+// GetMongoDB_PingTimeout bounds the ping that confirms the startup connection.
+// The server selection it waits on is what takes long over high-latency links.
+func GetMongoDB_PingTimeout() time.Duration {
+	return getDuration("MONGODB_PING_TIMEOUT", 10*time.Second)
+}
+
+// GetMongoDB_TLSCAFile returns the PEM file of CAs the MongoDB server
+// certificate is verified against, instead of the system roots.
+func GetMongoDB_TLSCAFile() string {
+	return os.Getenv("MONGODB_TLS_CA_FILE")
+}
+
+// GetMongoDB_TLSCertFile returns the PEM client certificate presented to
+// MongoDB, for X.509 authentication. It needs GetMongoDB_TLSKeyFile too.
+func GetMongoDB_TLSCertFile() string {
+	return os.Getenv("MONGODB_TLS_CERT_FILE")
+}
+
+func GetMongoDB_TLSKeyFile() string {
+	return os.Getenv("MONGODB_TLS_KEY_FILE")
+}
+
+// GetMongoDB_TLSInsecure reports whether to skip verifying the MongoDB server
+// certificate. Only meant for development.
+func GetMongoDB_TLSInsecure() bool {
+	insecure, _ := strconv.ParseBool(os.Getenv("MONGODB_TLS_INSECURE"))
+	return insecure
+}
+
+// GetCacheTTL returns how long the responses of the field listing and report
+// endpoints are cached for. CACHE_TTL=0 turns caching off.
+func GetCacheTTL() time.Duration {
+	if os.Getenv("CACHE_TTL") == "0" {
+		return 0
+	}
+	return getDuration("CACHE_TTL", 30*time.Second)
+}
+
+// GetShutdownTimeout returns how long shutdown waits for in-flight requests
+// to finish before closing their connections and disconnecting from MongoDB.
+func GetShutdownTimeout() time.Duration {
+	return getDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+}
+
+// GetHealthCheckInterval returns how often MongoDB is pinged in the
+// background for /healthz. HEALTHCHECK_INTERVAL=0 turns the monitor off, so
+// /healthz pings on every request instead.
+func GetHealthCheckInterval() time.Duration {
+	if os.Getenv("HEALTHCHECK_INTERVAL") == "0" {
+		return 0
+	}
+	return getDuration("HEALTHCHECK_INTERVAL", 10*time.Second)
+}
+
+// GetDefaultLimit returns the page size of list endpoints when clients don't
+// ask for one, as configured; empty means the built-in default.
+func GetDefaultLimit() string {
+	return os.Getenv("DEFAULT_LIMIT")
+}
+
+// GetCollectionDefaultLimit returns the configured default page size of one
+// collection, e.g. PRODUCTS_DEFAULT_LIMIT for products, overriding
+// GetDefaultLimit; empty means no override.
+func GetCollectionDefaultLimit(collection string) string {
+	return os.Getenv(strings.ToUpper(collection) + "_DEFAULT_LIMIT")
+}
+
+func GetHealthCheckTimeout() time.Duration {
+	return getDuration("HEALTHCHECK_TIMEOUT", 2*time.Second)
+}
+
+func GetRequestTimeout() time.Duration {
+	return getDuration("REQUEST_TIMEOUT", 5*time.Second)
+}
+
+// getUint reads an unsigned integer env var, falling back to def when it is
+// unset or invalid.
+func getUint(key string, def uint64) uint64 {
+	value, err := strconv.ParseUint(os.Getenv(key), 10, 64)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// getDuration reads a positive duration env var such as "5s", falling back to
+// def when it is unset or invalid.
+func getDuration(key string, def time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return def
+	}
+	return value
+}
+
+// getList reads a comma-separated env var, dropping blank entries.
+func getList(key string) []string {
+	var items []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}